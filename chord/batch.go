@@ -0,0 +1,276 @@
+package chord
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A key of a batch, sent to one of its replicas
+type batchEntry struct {
+	key     int    // Index of the key in the batch
+	replica int    // Index of the vnode among the replicas of the key
+	vnode   *Vnode // Replica the key is sent to
+}
+
+// MultiGet reads a batch of keys, returning the value and error of
+// each key as Get would. The keys are grouped by the hosts of their
+// replicas, and each host is sent a single request, all in parallel.
+// Unlike Get, it waits for every host to answer or time out.
+func (r *Ring) MultiGet(keys [][]byte, opts ...Option) ([][]byte, []error) {
+	o := r.options(opts)
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	replicas := r.batchReplicas(keys, errs)
+
+	// Read from every host at once
+	items := make([][]*Item, len(keys))
+	answered := make([][]bool, len(keys))
+	failed := make([][]error, len(keys))
+	for idx := range keys {
+		items[idx] = make([]*Item, len(replicas[idx]))
+		answered[idx] = make([]bool, len(replicas[idx]))
+		failed[idx] = make([]error, len(replicas[idx]))
+	}
+	r.sendBatches(replicas, func(entries []batchEntry) {
+		targets := make([]*Vnode, len(entries))
+		batchKeys := make([][]byte, len(entries))
+		for idx, e := range entries {
+			targets[idx], batchKeys[idx] = e.vnode, keys[e.key]
+		}
+		found, err := r.transport.MultiGet(targets, batchKeys)
+		for idx, e := range entries {
+			if err != nil {
+				failed[e.key][e.replica] = err
+			} else {
+				items[e.key][e.replica], answered[e.key][e.replica] = found[idx], true
+			}
+		}
+	})
+
+	// Merge the answers of each key
+	now := time.Now()
+	for idx, key := range keys {
+		if errs[idx] != nil {
+			continue
+		}
+		var acked []int
+		for rIdx, ok := range answered[idx] {
+			if ok {
+				acked = append(acked, rIdx)
+			}
+		}
+		if len(acked) < o.readQuorum {
+			errs[idx] = batchQuorumError(o.readQuorum, replicas[idx], acked, failed[idx])
+			continue
+		}
+		go r.readRepair(replicas[idx], items[idx], answered[idx])
+
+		merged := mergeAll(items[idx], acked)
+		if merged == nil {
+			errs[idx] = ErrKeyNotFound
+			continue
+		}
+		live := liveVersions(merged.versions(), now)
+		switch {
+		case len(live) == 0:
+			errs[idx] = ErrKeyNotFound
+		case len(live) > 1:
+			conflict := &ConflictError{Key: key}
+			for _, v := range live {
+				conflict.Values = append(conflict.Values, v.Value)
+			}
+			conflict.Context, errs[idx] = encodeContext(merged.versions())
+			if errs[idx] == nil {
+				errs[idx] = conflict
+			}
+		default:
+			values[idx] = live[0].Value
+		}
+	}
+	return values, errs
+}
+
+// MultiPut stores a batch of values, one for each key, returning the
+// error of each key as Put would. The keys are grouped by the hosts
+// of their replicas, and each host is sent a single request, all in
+// parallel, first to the owners of the keys and then to the other
+// replicas. Replicas that are down are given hints. A context token
+// given using WithContext applies to every key.
+func (r *Ring) MultiPut(keys, values [][]byte, opts ...Option) []error {
+	o := r.options(opts)
+	errs := make([]error, len(keys))
+	context, err := decodeContext(o.context)
+	if err == nil && len(values) != len(keys) {
+		err = fmt.Errorf("Got %d values for %d keys", len(values), len(keys))
+	}
+	if err != nil {
+		for idx := range errs {
+			errs[idx] = err
+		}
+		return errs
+	}
+	replicas := r.batchReplicas(keys, errs)
+
+	items := make([]*Item, len(keys))
+	for idx, key := range keys {
+		clock := context.Copy()
+		r.tick(clock)
+		items[idx] = &Item{Key: key, Value: values[idx], Clock: clock}
+		if o.ttl > 0 {
+			items[idx].Expires = time.Now().Add(o.ttl)
+		}
+	}
+
+	// The owners refuse keys locked by a transaction, so they are
+	// written first, and the keys they refuse are not replicated
+	owners := make([][]*Vnode, len(keys))
+	for idx, vns := range replicas {
+		if errs[idx] == nil {
+			owners[idx] = vns[:1]
+		}
+	}
+	ownerAcked, ownerFailed := r.putBatches(items, owners)
+	rest := make([][]*Vnode, len(keys))
+	for idx, vns := range replicas {
+		if errs[idx] == nil && ownerFailed[idx][0] == ErrKeyLocked {
+			errs[idx] = ErrKeyLocked
+		} else if errs[idx] == nil {
+			rest[idx] = vns[1:]
+		}
+	}
+	restAcked, restFailed := r.putBatches(items, rest)
+
+	for idx := range keys {
+		if errs[idx] != nil {
+			continue
+		}
+		acked := ownerAcked[idx]
+		for _, rIdx := range restAcked[idx] {
+			acked = append(acked, rIdx+1)
+		}
+		if len(acked) < o.writeQuorum {
+			failed := append(ownerFailed[idx], restFailed[idx]...)
+			errs[idx] = batchQuorumError(o.writeQuorum, replicas[idx], acked, failed)
+		}
+	}
+	return errs
+}
+
+// Sends each item to the replicas of its key, batched by host, giving
+// hints to the replicas that failed and are down. Returns the replicas
+// that stored each item, in order, along with the error of each
+// replica that did not.
+func (r *Ring) putBatches(items []*Item, replicas [][]*Vnode) ([][]int, [][]error) {
+	stored := make([][]bool, len(items))
+	failed := make([][]error, len(items))
+	for idx := range items {
+		stored[idx] = make([]bool, len(replicas[idx]))
+		failed[idx] = make([]error, len(replicas[idx]))
+	}
+	r.sendBatches(replicas, func(entries []batchEntry) {
+		targets := make([]*Vnode, len(entries))
+		batch := make([]*Item, len(entries))
+		for idx, e := range entries {
+			targets[idx], batch[idx] = e.vnode, items[e.key]
+		}
+		entryErrs, err := r.transport.MultiPut(targets, batch)
+		for idx, e := range entries {
+			failure := err
+			if failure == nil {
+				failure = entryErrs[idx]
+			}
+			if failure == nil {
+				stored[e.key][e.replica] = true
+				continue
+			}
+			failed[e.key][e.replica] = failure
+			r.hintIfDown(e.vnode, items[e.key])
+		}
+	})
+
+	acked := make([][]int, len(items))
+	for idx := range items {
+		for rIdx, ok := range stored[idx] {
+			if ok {
+				acked[idx] = append(acked[idx], rIdx)
+			}
+		}
+	}
+	return acked, failed
+}
+
+// Finds the replicas of each key, setting the error of the keys whose
+// replicas cannot be found. Keys are looked up in hash order, so that
+// keys with the same owner share a single lookup.
+func (r *Ring) batchReplicas(keys [][]byte, errs []error) [][]*Vnode {
+	hashes := make([][]byte, len(keys))
+	order := make([]int, len(keys))
+	for idx, key := range keys {
+		hashes[idx] = r.hashKey(key)
+		order[idx] = idx
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return string(hashes[order[i]]) < string(hashes[order[j]])
+	})
+
+	replicas := make([][]*Vnode, len(keys))
+	var last []byte
+	var lastReplicas []*Vnode
+	for _, idx := range order {
+		hash := hashes[idx]
+
+		// The owner of the previous key also owns this one
+		if lastReplicas != nil && betweenRightIncl(last, lastReplicas[0].Id, hash) {
+			replicas[idx] = lastReplicas
+			last = hash
+			continue
+		}
+		found, err := r.replicasForHash(hash)
+		if err != nil {
+			errs[idx] = &UnreachableError{Err: err}
+			lastReplicas = nil
+			continue
+		}
+		replicas[idx], last, lastReplicas = found, hash, found
+	}
+	return replicas
+}
+
+// Groups the keys by the host of each of their replicas,
+// and runs send for each host in parallel
+func (r *Ring) sendBatches(replicas [][]*Vnode, send func([]batchEntry)) {
+	batches := make(map[string][]batchEntry)
+	for kIdx, vns := range replicas {
+		for rIdx, vn := range vns {
+			batches[vn.Host] = append(batches[vn.Host], batchEntry{kIdx, rIdx, vn})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, entries := range batches {
+		wg.Add(1)
+		go func(entries []batchEntry) {
+			defer wg.Done()
+			send(entries)
+		}(entries)
+	}
+	wg.Wait()
+}
+
+// Returns the error of a key whose batch did not reach the quorum,
+// given the error of each replica that failed
+func batchQuorumError(need int, replicas []*Vnode, acked []int, failed []error) error {
+	if need > len(replicas) {
+		return &QuorumError{Required: need,
+			Err: fmt.Errorf("Only %d replicas available", len(replicas))}
+	}
+	var err error
+	for rIdx, vn := range replicas {
+		if failed[rIdx] != nil {
+			err = mergeErrors(err, &UnreachableError{Vnode: vn, Err: failed[rIdx]})
+		}
+	}
+	return &QuorumError{Required: need, Acks: len(acked), Err: err}
+}
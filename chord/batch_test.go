@@ -0,0 +1,204 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Transport counting the batches sent to remote hosts
+type batchCountTrans struct {
+	*failTrans
+	gets atomic.Int32
+	puts atomic.Int32
+}
+
+func (b *batchCountTrans) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	b.gets.Add(1)
+	return b.failTrans.MultiGet(targets, keys)
+}
+
+func (b *batchCountTrans) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	b.puts.Add(1)
+	return b.failTrans.MultiPut(targets, items)
+}
+
+func TestRingMultiGetPut(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	bt := &batchCountTrans{failTrans: &failTrans{MultiLocalTrans: ml}}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), bt)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), bt, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key%d", i)))
+	}
+	for idx, err := range r.MultiPut(keys, keys, WithWriteQuorum(2)) {
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", keys[idx], err)
+		}
+	}
+
+	// The other host is sent a batch for the keys it owns,
+	// then one for the keys it replicates
+	if n := bt.puts.Load(); n != 2 {
+		t.Fatalf("expected 2 remote batches, got %d", n)
+	}
+
+	// Read them back through the other ring, along with a missing key
+	values, errs := r2.MultiGet(append(keys, []byte("missing")), WithReadQuorum(2))
+	for idx, key := range keys {
+		if errs[idx] != nil {
+			t.Fatalf("unexpected err for %s. %s", key, errs[idx])
+		}
+		if !bytes.Equal(values[idx], key) {
+			t.Fatalf("bad value for %s: %s", key, values[idx])
+		}
+	}
+	if errs[100] != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", errs[100])
+	}
+	if n := bt.gets.Load(); n != 1 {
+		t.Fatalf("expected 1 remote batch, got %d", n)
+	}
+
+	// A write through another host without a context conflicts,
+	// which a batch written with the context resolves
+	key := [][]byte{[]byte("ctx")}
+	r.MultiPut(key, key, WithWriteQuorum(2))
+	r2.MultiPut(key, [][]byte{[]byte("other")}, WithWriteQuorum(2))
+	versions, ctx, err := r.GetVersions(key[0], WithReadQuorum(2))
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("expected 2 siblings, got %q %v", versions, err)
+	}
+	if errs := r.MultiPut(key, [][]byte{[]byte("merged")}, WithContext(ctx), WithWriteQuorum(2)); errs[0] != nil {
+		t.Fatalf("unexpected err. %s", errs[0])
+	}
+	versions, _, err = r2.GetVersions(key[0], WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(versions) != 1 || !bytes.Equal(versions[0], []byte("merged")) {
+		t.Fatalf("bad values: %q", versions)
+	}
+	if errs := r.MultiPut(key, key, WithContext([]byte("bad"))); errs[0] == nil {
+		t.Fatalf("expected a bad context to fail")
+	}
+
+	// The quorum is checked for each key
+	bt.fail("test")
+	values, errs = r2.MultiGet(keys[:10])
+	for idx := range values {
+		if errs[idx] != nil || !bytes.Equal(values[idx], keys[idx]) {
+			t.Fatalf("unexpected result for %s. %s %v", keys[idx], values[idx], errs[idx])
+		}
+	}
+	_, errs = r2.MultiGet(keys[:10], WithReadQuorum(2))
+	for idx := range errs {
+		if qerr, ok := errs[idx].(*QuorumError); !ok || qerr.Acks != 1 {
+			t.Fatalf("expected quorum error, got %v", errs[idx])
+		}
+	}
+}
+
+func TestTCPMultiGetPut(t *testing.T) {
+	listen := "localhost:10044"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnodes directly over TCP
+	vn1, vn2 := &r.Vnodes[0].Vnode, &r.Vnodes[1].Vnode
+	missing := &Vnode{Id: []byte{1}, Host: vn1.Host}
+	items := []*Item{
+		{Key: []byte("foo"), Value: []byte("bar"), Clock: VectorClock{"test": 1}},
+		{Key: []byte("lost"), Value: []byte("bar"), Clock: VectorClock{"test": 1}},
+		{Key: []byte("baz"), Value: []byte("qux"), Clock: VectorClock{"test": 1}},
+	}
+
+	// Only the item for the unknown vnode fails
+	errs, err := trans.MultiPut([]*Vnode{vn1, missing, vn2}, items)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("bad errors: %v", errs)
+	}
+	found, err := trans.MultiGet([]*Vnode{vn1, vn2, vn2},
+		[][]byte{[]byte("foo"), []byte("missing"), []byte("baz")})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(found) != 3 || found[1] != nil {
+		t.Fatalf("bad items: %v", found)
+	}
+	if !bytes.Equal(found[0].Value, []byte("bar")) || !bytes.Equal(found[2].Value, []byte("qux")) {
+		t.Fatalf("bad items: %v", found)
+	}
+}
+
+func TestRingMultiPutLockedKey(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Prepare a transaction writing a key
+	key, owner := keyOwnedBy(t, r, "test")
+	vn := ringVnode(owner, r)
+	items := []*Item{{Key: key, Value: []byte("txn")}}
+	if err := vn.TxnPrepare("test/1", owner, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// The owner refuses the key, and the other replicas are not written
+	errs := r.MultiPut([][]byte{key}, [][]byte{[]byte("multi")})
+	if errs[0] != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", errs[0])
+	}
+	for _, local := range r2.Vnodes {
+		if _, err := local.Get(key); err != ErrKeyNotFound {
+			t.Fatalf("expected no replica of the key, got %v", err)
+		}
+	}
+	if _, err := r.Get(key, WithReadQuorum(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
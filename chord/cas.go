@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"fmt"
+	"time"
+)
+
+// CASError is returned by a conditional write whose condition
+// did not hold when it reached the vnode owning the key
+type CASError struct {
+	Key      []byte // Key written
+	Expected uint64 // Version the write expected
+	Current  uint64 // Version of the stored value, 0 if there is none
+	Exists   bool   // Whether the key has a value
+}
+
+func (e *CASError) Error() string {
+	if !e.Exists {
+		return fmt.Sprintf("Key %q has no value, expected version %d", e.Key, e.Expected)
+	}
+	return fmt.Sprintf("Key %q is at version %d, expected %d", e.Key, e.Current, e.Expected)
+}
+
+// ReplicationError is returned by a write that the vnode owning the
+// key applied, but whose value did not reach the write quorum. The
+// write took effect, so it must not be retried.
+type ReplicationError struct {
+	Item *Item // Item stored by the owner, with its new version
+	Err  error // Errors from the replicas that failed
+}
+
+func (e *ReplicationError) Error() string {
+	return fmt.Sprintf("Key %q written at version %d, but failed to replicate! Got %s", e.Item.Key, e.Item.Version, e.Err)
+}
+
+// GetWithVersion returns the value stored under a key along with its
+// version, to pass to CompareAndSwap. Each write applied by the owner
+// of the key advances its version, whether it is a Put or a swap.
+func (r *Ring) GetWithVersion(key []byte, opts ...Option) ([]byte, uint64, error) {
+	item, err := r.read(key, r.options(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	live := liveVersions(item.versions(), time.Now())
+	if len(live) == 0 {
+		return nil, 0, ErrKeyNotFound
+	}
+	if len(live) > 1 {
+		values := make([][]byte, len(live))
+		for idx, v := range live {
+			values[idx] = v.Value
+		}
+		ctx, err := encodeContext(item.versions())
+		if err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, &ConflictError{Key: key, Values: values, Context: ctx}
+	}
+	return live[0].Value, item.Version, nil
+}
+
+// CompareAndSwap replaces the value stored under a key if it is still
+// at the expected version, returning the new version. The check and
+// the write happen atomically on the vnode owning the key, which then
+// replicates the value, so a swap needs the owner to be reachable.
+// Returns a *CASError if the key is at another version or has no value.
+// Any write the owner applied since the expected version fails the swap,
+// and ErrKeyLocked is returned while a transaction is writing the key.
+// If the swap was applied but not replicated to the write quorum, the
+// new version is returned along with a *ReplicationError.
+func (r *Ring) CompareAndSwap(key []byte, expected uint64, value []byte, opts ...Option) (uint64, error) {
+	return r.conditionalPut(key, value, expected, false, r.options(opts))
+}
+
+// PutIfAbsent stores a value under a key unless it already has one,
+// returning the new version. Returns a *CASError if it has a value.
+func (r *Ring) PutIfAbsent(key, value []byte, opts ...Option) (uint64, error) {
+	return r.conditionalPut(key, value, 0, true, r.options(opts))
+}
+
+// Runs a conditional write on the owner of a key, and replicates it
+func (r *Ring) conditionalPut(key, value []byte, expected uint64, absent bool, o *options) (uint64, error) {
+	item := &Item{Key: key, Value: value}
+	if o.ttl > 0 {
+		item.Expires = time.Now().Add(o.ttl)
+	}
+	item, err := r.ownerWrite(key, o, func(owner *Vnode) (*Item, error) {
+		return r.transport.CompareAndSwap(owner, item, expected, absent)
+	})
+	if item == nil {
+		return 0, err
+	}
+	return item.Version, err
+}
+
+// Runs a write on the owner of a key, and replicates the item it
+// stored. Errors other than a failed condition, a bad counter, an
+// overflow or a locked key mean the owner could not be reached. If
+// the replication fails, the item is returned with a *ReplicationError.
+func (r *Ring) ownerWrite(key []byte, o *options, f func(owner *Vnode) (*Item, error)) (*Item, error) {
+	replicas, err := r.replicas(key)
+	if err != nil {
+		return nil, err
+	}
+	item, err := f(replicas[0])
+	if err != nil {
+		if _, ok := err.(*CASError); ok || err == ErrNotCounter || err == ErrCounterOverflow || err == ErrKeyLocked {
+			return nil, err
+		}
+		return nil, &UnreachableError{Vnode: replicas[0], Err: err}
+	}
+	if err := r.write(item, o); err != nil {
+		return item, &ReplicationError{Item: item, Err: err}
+	}
+	return item, nil
+}
+
+// RPC: Stores an item if the stored value is at the expected version,
+// or if absent is set, if there is no value
+func (vn *LocalVnode) CompareAndSwap(item *Item, expected uint64, absent bool) (*Item, error) {
+	return vn.update(item.Key, func(cur *Version, version uint64) (*Version, error) {
+		exists := cur != nil
+		if exists == absent || (exists && version != expected) {
+			cerr := &CASError{Key: item.Key, Expected: expected, Exists: exists}
+			if exists {
+				cerr.Current = version
+			}
+			return nil, cerr
+		}
+		return &Version{Value: item.Value, Expires: item.Expires}, nil
+	})
+}
+
+/*
+Replaces the value of a key with the one returned by f, atomically
+with respect to other writes on this vnode. f is given the current
+value, or nil if there is none, along with the version of the key. If
+concurrent writes left several values, f is given the first. The new
+value gets the next version, and a clock superseding every stored version.
+Fails with ErrKeyLocked if a prepared transaction holds the key.
+*/
+func (vn *LocalVnode) update(key []byte, f func(cur *Version, version uint64) (*Version, error)) (*Item, error) {
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	if _, ok := vn.txnLocks[string(key)]; ok {
+		return nil, ErrKeyLocked
+	}
+	return vn.apply(key, f)
+}
+
+// Runs an update without checking the transaction locks, for the
+// transaction holding them. The txn lock must be held.
+func (vn *LocalVnode) apply(key []byte, f func(cur *Version, version uint64) (*Version, error)) (*Item, error) {
+	vn.writeLock.Lock()
+	defer vn.writeLock.Unlock()
+	if vn.left.Load() {
+		return nil, errVnodeLeft
+	}
+
+	clock := VectorClock{}
+	var cur *Version
+	var version uint64
+	old, err := vn.Store.Get(key)
+	if err == nil {
+		version = old.Version
+		if live := liveVersions(old.versions(), time.Now()); len(live) > 0 {
+			cur = live[0]
+		}
+		for _, v := range old.versions() {
+			clock.Merge(v.Clock)
+		}
+	} else if err != ErrKeyNotFound {
+		return nil, err
+	}
+
+	next, err := f(cur, version)
+	if err != nil {
+		return nil, err
+	}
+	vn.Ring.tick(clock)
+	put := &Item{Key: key, Hash: vn.Ring.hashKey(key), Value: next.Value, Clock: clock,
+		Deleted: next.Deleted, Expires: next.Expires, Version: version + 1}
+	if err := vn.Store.Put(put); err != nil {
+		return nil, err
+	}
+	vn.notifyWatchers(key, put)
+	return put, nil
+}
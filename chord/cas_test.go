@@ -0,0 +1,215 @@
+package chord
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingCompareAndSwap(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("lock")
+	ver, err := r.PutIfAbsent(key, []byte("a"))
+	if err != nil || ver != 1 {
+		t.Fatalf("unexpected result. %d %v", ver, err)
+	}
+
+	// The key is taken
+	_, err = r.PutIfAbsent(key, []byte("b"))
+	cerr, ok := err.(*CASError)
+	if !ok || !cerr.Exists || cerr.Current != 1 {
+		t.Fatalf("expected cas error, got %v", err)
+	}
+
+	// Swap from the current version only
+	if ver, err = r.CompareAndSwap(key, 1, []byte("b")); err != nil || ver != 2 {
+		t.Fatalf("unexpected result. %d %v", ver, err)
+	}
+	_, err = r.CompareAndSwap(key, 1, []byte("c"))
+	if cerr, ok := err.(*CASError); !ok || cerr.Current != 2 || cerr.Expected != 1 {
+		t.Fatalf("expected cas error, got %v", err)
+	}
+	val, ver, err := r.GetWithVersion(key)
+	if err != nil || ver != 2 || !bytes.Equal(val, []byte("b")) {
+		t.Fatalf("unexpected result. %s %d %v", val, ver, err)
+	}
+
+	// Deleted keys can be taken again, at a later version
+	if err := r.Delete(key); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	_, err = r.CompareAndSwap(key, 2, []byte("c"))
+	if cerr, ok := err.(*CASError); !ok || cerr.Exists {
+		t.Fatalf("expected cas error, got %v", err)
+	}
+	if ver, err = r.PutIfAbsent(key, []byte("d")); err != nil || ver != 4 {
+		t.Fatalf("unexpected result. %d %v", ver, err)
+	}
+}
+
+func TestRingCompareAndSwapUnderReplicated(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// The replica cannot be written, but the owner applies the swap
+	key, _ := keyOwnedBy(t, r, "test")
+	ft.fail("test2")
+	ver, err := r.PutIfAbsent(key, []byte("a"), WithWriteQuorum(2))
+	rerr, ok := err.(*ReplicationError)
+	if !ok || ver != 1 || rerr.Item.Version != 1 {
+		t.Fatalf("expected replication error, got %d %v", ver, err)
+	}
+
+	// The new version is the one to swap from
+	ver, err = r.CompareAndSwap(key, ver, []byte("b"), WithWriteQuorum(2))
+	if _, ok := err.(*ReplicationError); !ok || ver != 2 {
+		t.Fatalf("expected replication error, got %d %v", ver, err)
+	}
+	val, ver, err := r.GetWithVersion(key)
+	if err != nil || ver != 2 || !bytes.Equal(val, []byte("b")) {
+		t.Fatalf("unexpected result. %s %d %v", val, ver, err)
+	}
+}
+
+func TestRingCompareAndSwapAfterPut(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("lock")
+	ver, err := r.PutIfAbsent(key, []byte("a"))
+	if err != nil || ver != 1 {
+		t.Fatalf("unexpected result. %d %v", ver, err)
+	}
+
+	// A plain Put advances the version
+	if err := r.Put(key, []byte("other")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	val, ver, err := r.GetWithVersion(key)
+	if err != nil || ver != 2 || !bytes.Equal(val, []byte("other")) {
+		t.Fatalf("unexpected result. %s %d %v", val, ver, err)
+	}
+
+	// So a swap from the version before it fails
+	_, err = r.CompareAndSwap(key, 1, []byte("mine"))
+	if cerr, ok := err.(*CASError); !ok || cerr.Current != 2 {
+		t.Fatalf("expected cas error, got %v", err)
+	}
+	if val, _, _ := r.GetWithVersion(key); !bytes.Equal(val, []byte("other")) {
+		t.Fatalf("the swap overwrote the put: %s", val)
+	}
+}
+
+func TestRingCompareAndSwapCounter(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	key := []byte("counter")
+	if _, err := r.PutIfAbsent(key, []byte("0"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Increment the counter through both rings at once
+	var wg sync.WaitGroup
+	for _, ring := range []*Ring{r, r2, r, r2} {
+		wg.Add(1)
+		go func(ring *Ring) {
+			defer wg.Done()
+			for i := 0; i < 10; {
+				val, ver, err := ring.GetWithVersion(key, WithReadQuorum(2))
+				if err != nil {
+					t.Errorf("unexpected err. %s", err)
+					return
+				}
+				n, _ := strconv.Atoi(string(val))
+				_, err = ring.CompareAndSwap(key, ver, []byte(strconv.Itoa(n+1)), WithWriteQuorum(2))
+				if _, ok := err.(*CASError); ok {
+					continue
+				} else if err != nil {
+					t.Errorf("unexpected err. %s", err)
+					return
+				}
+				i++
+			}
+		}(ring)
+	}
+	wg.Wait()
+
+	val, ver, err := r2.GetWithVersion(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if string(val) != "40" || ver != 41 {
+		t.Fatalf("bad counter: %s at version %d", val, ver)
+	}
+}
+
+func TestTCPCompareAndSwap(t *testing.T) {
+	listen := "localhost:10042"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	vn := &r.Vnodes[0].Vnode
+	item, err := trans.CompareAndSwap(vn, &Item{Key: []byte("foo"), Value: []byte("bar")}, 0, true)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if item.Version != 1 || len(item.Clock) != 1 {
+		t.Fatalf("bad item: %+v", item)
+	}
+	_, err = trans.CompareAndSwap(vn, &Item{Key: []byte("foo"), Value: []byte("baz")}, 0, true)
+	if cerr, ok := err.(*CASError); !ok || cerr.Current != 1 {
+		t.Fatalf("expected cas error, got %v", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"hash"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,9 +39,66 @@ type Transport interface {
 	// Register for an RPC callbacks
 	Register(*Vnode, VnodeRPC)
 
-	PutKey(*Vnode, int) error 
+	// Read the item stored under a key on a vnode
+	Get(target *Vnode, key []byte) (*Item, error)
 
-	GetKey(*Vnode) (int, error)
+	// Store a versioned item on a vnode
+	Put(target *Vnode, item *Item) error
+
+	// Delete a key on a vnode, leaving a tombstone
+	Delete(target *Vnode, key []byte) error
+
+	// Read the items stored under keys on vnodes of a single host,
+	// with a nil item for each key that is not found
+	MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error)
+
+	// Store versioned items on vnodes of a single host, returning
+	// the error of each item, or an error if the host failed
+	MultiPut(targets []*Vnode, items []*Item) ([]error, error)
+
+	// Store an item on a vnode if the key is at the expected version, or absent
+	CompareAndSwap(target *Vnode, item *Item, expected uint64, absent bool) (*Item, error)
+
+	// Add to the counter stored under a key on a vnode
+	Increment(target *Vnode, key []byte, delta int64) (*Item, error)
+
+	// Add data to the end of the value stored under a key on a vnode
+	Append(target *Vnode, key, data []byte) (*Item, error)
+
+	// Subscribe to the changes of a key on the vnode owning it,
+	// returning the item stored under the key
+	Watch(target *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error)
+
+	// Unsubscribe from the changes of a key
+	Unwatch(target *Vnode, key []byte, id string) error
+
+	// Push a change to a watch held by the host of a vnode,
+	// returning false if the watch is no longer open
+	WatchEvent(target *Vnode, id string, ev *WatchEvent) (bool, error)
+
+	// Lock keys on a vnode and hold writes for a transaction
+	TxnPrepare(target *Vnode, id string, coordinator *Vnode, items []*Item, timeout time.Duration) error
+
+	// Make the writes held for a transaction on a vnode
+	TxnCommit(target *Vnode, id string) ([]*Item, error)
+
+	// Drop the writes held for a transaction on a vnode
+	TxnAbort(target *Vnode, id string) error
+
+	// Ask the coordinator of a transaction for its outcome
+	TxnStatus(target *Vnode, id string) (TxnState, error)
+
+	// List up to limit items on a vnode with hashes in (start, end]
+	Scan(target *Vnode, start, end []byte, limit int) ([]*Item, error)
+
+	// Hand over items for a vnode to store
+	Transfer(target *Vnode, items []*Item) error
+
+	// Get the hashes of nodes of a vnode's Merkle tree over (start, end]
+	MerkleNodes(target *Vnode, start, end []byte, nodes []int) ([][]byte, error)
+
+	// Get the digests of a vnode's keys in (start, end] within Merkle tree leaves
+	KeyDigests(target *Vnode, start, end []byte, leaves []int) ([]*KeyDigest, error)
 }
 
 // These are the methods to invoke on the registered vnodes
@@ -49,8 +108,23 @@ type VnodeRPC interface {
 	FindSuccessors(int, []byte) ([]*Vnode, error)
 	ClearPredecessor(*Vnode) error
 	SkipSuccessor(*Vnode) error
-	PutKey(int) error 
-	GetKey() (int, error)
+	Get([]byte) (*Item, error)
+	Put(*Item) error
+	Delete([]byte) error
+	CompareAndSwap(item *Item, expected uint64, absent bool) (*Item, error)
+	Increment(key []byte, delta int64) (*Item, error)
+	Append(key, data []byte) (*Item, error)
+	Watch(key []byte, id string, subscriber *Vnode) (*Item, error)
+	Unwatch(key []byte, id string) error
+	WatchEvent(id string, ev *WatchEvent) (bool, error)
+	TxnPrepare(id string, coordinator *Vnode, items []*Item, timeout time.Duration) error
+	TxnCommit(id string) ([]*Item, error)
+	TxnAbort(id string) error
+	TxnStatus(id string) (TxnState, error)
+	Scan(start, end []byte, limit int) ([]*Item, error)
+	Transfer([]*Item) error
+	MerkleNodes(start, end []byte, nodes []int) ([][]byte, error)
+	KeyDigests(start, end []byte, leaves []int) ([]*KeyDigest, error)
 }
 
 // Delegate to notify on ring events
@@ -64,34 +138,74 @@ type Delegate interface {
 
 // Configuration for Chord nodes
 type Config struct {
-	Hostname      string           // Local host name
-	NumVnodes     int              // Number of vnodes per physical node
-	HashFunc      func() hash.Hash // Hash function to use
-	StabilizeMin  time.Duration    // Minimum stabilization time
-	StabilizeMax  time.Duration    // Maximum stabilization time
-	NumSuccessors int              // Number of successors to maintain
-	Delegate      Delegate         // Invoked to handle ring events
-	HashBits      int              // Bit size of the hash function
+	Hostname            string           // Local host name
+	NumVnodes           int              // Number of vnodes per physical node
+	HashFunc            func() hash.Hash // Hash function to use
+	StabilizeMin        time.Duration    // Minimum stabilization time
+	StabilizeMax        time.Duration    // Maximum stabilization time
+	NumSuccessors       int              // Number of successors to maintain
+	Delegate            Delegate         // Invoked to handle ring events
+	HashBits            int              // Bit size of the hash function
+	StoreFunc           StoreFunc        // Opens the store of a local vnode, in-memory if nil
+	DataDir             string           // Directory for durable vnode stores, used if StoreFunc is nil
+	SyncPolicy          SyncPolicy       // When durable stores flush their log to disk
+	SyncInterval        time.Duration    // Flush interval for the SyncPeriodic policy
+	CompactThreshold    int              // Log records written before a durable store compacts
+	LeaveTimeout        time.Duration    // Time allowed to hand off keys when leaving
+	GracefulShutdown    bool             // Hand off keys and leave the ring on Shutdown
+	ReplicationFactor   int              // Number of distinct hosts storing each key
+	ReadQuorum          int              // Replicas that must answer a Get
+	WriteQuorum         int              // Replicas that must acknowledge a Put or Delete
+	AntiEntropyInterval time.Duration    // Time between anti-entropy rounds with replicas
+	MaxHints            int              // Writes held for unreachable replicas before dropping new ones
+	TombstoneGrace      time.Duration    // Time deletes are remembered before being purged
+	SweepInterval       time.Duration    // Time between sweeps for expired values and tombstones
+	TxnTimeout          time.Duration    // Time a transaction may hold its locks before it is aborted
+	Logger              Logger           // Receives structured log messages, discarded if nil
+	UnhealthyAfter      int              // Consecutive failed stabilizations before a vnode is unhealthy
 }
 
+// StoreFunc opens the Store holding the items of a local vnode
+type StoreFunc func(vn *Vnode) (Store, error)
+
 // Represents an Vnode, local or remote
 type Vnode struct {
 	Id   []byte // Virtual ID
 	Host string // Host identifier
-	Map	 map[string]string
-	Test int
 }
 
-// Represents a local Vnode
+/*
+Represents a local Vnode. The routing state, from Successors to Timer,
+is changed by stabilization while RPCs read it, so it is guarded by
+lock, along with the queued handoffs. It is never held while calling
+out to another vnode, and RPCs return copies of it.
+*/
 type LocalVnode struct {
 	Vnode
-	Ring        *Ring
-	Successors  []*Vnode
-	Finger      []*Vnode
-	Last_finger int
-	Predecessor *Vnode
-	Stabilized  time.Time
-	Timer       *time.Timer
+	Ring            *Ring
+	Successors      []*Vnode
+	Finger          []*Vnode
+	Last_finger     int
+	Predecessor     *Vnode
+	Stabilized      time.Time
+	lastError       error // Error of the last failed stabilization
+	failures        int   // Consecutive failed stabilizations
+	Timer           *time.Timer
+	Store           Store
+	lock            sync.RWMutex
+	handoffs        []*handoff
+	left            atomic.Bool // Set once we leave, to refuse new keys
+	writeLock       sync.Mutex  // Held by every change to the store
+	lastAntiEntropy time.Time
+	merkleLock      sync.Mutex
+	merkleCache     *cachedMerkle // Tree of the last sync asked of us
+	lastSweep       time.Time
+	watchLock       sync.Mutex
+	watchers        map[string]map[string]*watcher // Watches of each key, by id
+	txnLock         sync.Mutex
+	txnStore        Store                   // Prepared transactions, durable if Config.DataDir is set
+	prepared        map[string]*preparedTxn // Transactions holding locks, by id
+	txnLocks        map[string]string       // Transaction holding each locked key
 }
 
 // Stores the state required for a Chord ring
@@ -100,7 +214,21 @@ type Ring struct {
 	transport  Transport
 	Vnodes     []*LocalVnode
 	delegateCh chan func()
-	shutdown   chan bool
+	shutdown   chan bool // Set to stop the vnodes, guarded by stopLock
+	stopLock   sync.Mutex
+	stats      ringStats
+	hints      *hintStore
+	txns       *txnLog
+	clockActor string        // Our entry in vector clocks, unique to this run
+	clockSeq   atomic.Uint64 // Writes coordinated as clockActor
+
+	watchLock        sync.Mutex
+	watches          map[string]*Watch // Our watches, by id
+	watchSeq         uint64
+	lastWatchRefresh time.Time
+
+	eventLock     sync.RWMutex
+	subscriptions map[*Subscription]struct{} // Subscribers to our events
 }
 
 // Returns the default Ring configuration
@@ -114,6 +242,23 @@ func DefaultConfig(hostname string) *Config {
 		8,   // 8 successors
 		nil, // No delegate
 		160, // 160bit hash function
+		nil, // In-memory store
+		"",  // No data directory
+		SyncAlways,
+		time.Duration(time.Second),
+		1024, // Compact every 1024 writes
+		time.Duration(30 * time.Second),
+		false, // Shutdown without leaving
+		3,     // 3 replicas
+		1,     // Read from 1 replica
+		1,     // Write to 1 replica
+		time.Duration(time.Minute),
+		10000, // Hold up to 10000 hints
+		time.Duration(24 * time.Hour),
+		time.Duration(time.Minute),
+		time.Duration(10 * time.Second),
+		nil, // Discard log messages
+		3,   // Unhealthy after 3 failed stabilizations
 	}
 }
 
@@ -121,15 +266,17 @@ func DefaultConfig(hostname string) *Config {
 func Create(conf *Config, trans Transport) (*Ring, error) {
 	
 	ring := &Ring{}
-	ring.init(conf, trans)
+	if err := ring.init(conf, trans); err != nil {
+		return nil, err
+	}
 	ring.delegateCh = make(chan func(), 32)
 
 	ring.setLocalSuccessors()
 	//ring.setLocalPredecessor()
 	ring.schedule()
 	//conf.HashBits = conf.HashFunc().Size() * 8
-	//fmt.Println(conf.HashBits)
 
+	ring.logger().Info("Created ring", "host", conf.Hostname, "vnodes", conf.NumVnodes)
 	return ring, nil
 }
 
@@ -150,7 +297,9 @@ func Join(conf *Config, trans Transport, existing string) (*Ring, error) {
 
 	// Create a ring
 	ring := &Ring{}
-	ring.init(conf, trans)
+	if err := ring.init(conf, trans); err != nil {
+		return nil, err
+	}
 
 	// Acquire a live successor for each Vnode
 	for _, vn := range ring.Vnodes {
@@ -167,9 +316,11 @@ func Join(conf *Config, trans Transport, existing string) (*Ring, error) {
 		}
 
 		// Assign the successors
+		vn.lock.Lock()
 		for idx, s := range succs {
 			vn.Successors[idx] = s
 		}
+		vn.lock.Unlock()
 	}
 
 	// Start delegate handler
@@ -181,30 +332,74 @@ func Join(conf *Config, trans Transport, existing string) (*Ring, error) {
 	for _, vn := range ring.Vnodes {
 		vn.stabilize()
 	}
+	ring.logger().Info("Joined ring", "host", conf.Hostname, "vnodes", conf.NumVnodes, "existing", existing)
 	return ring, nil
 }
 
-// Leaves a given Chord ring and shuts down the local vnodes
-func (r *Ring) leave() error {
+// Leaves a given Chord ring and shuts down the local vnodes. The keys
+// held by each vnode are handed off to the first successor on another
+// host, and a *HandoffError lists any that could not be moved before
+// Config.LeaveTimeout expired.
+func (r *Ring) Leave() error {
+	r.stopWatches()
+
 	// Shutdown the vnodes first to avoid further stabilization runs
 	r.stopVnodes()
 
 	// Instruct each vnode to leave
 	var err error
+	herr := &HandoffError{}
+	deadline := time.Now().Add(leaveTimeout(r.config))
 	for _, vn := range r.Vnodes {
-		err = mergeErrors(err, vn.Leave())
+		verr := vn.leave(r.leaveTarget(vn), deadline)
+		if e, ok := verr.(*HandoffError); ok {
+			herr.Keys = append(herr.Keys, e.Keys...)
+			herr.Err = mergeErrors(herr.Err, e.Err)
+		} else {
+			err = mergeErrors(err, verr)
+		}
 	}
 
 	// Wait for the delegate callbacks to complete
 	r.stopDelegate()
+	r.stopEvents()
+	r.logger().Info("Left ring", "host", r.config.Hostname, "keysLeft", len(herr.Keys))
+	err = mergeErrors(err, r.closeStores())
+	if len(herr.Keys) > 0 {
+		herr.Err = mergeErrors(herr.Err, err)
+		return herr
+	}
 	return err
 }
 
+// Returns the successor a vnode hands its keys to when the whole
+// ring leaves, skipping the vnodes on our host that leave with it
+func (r *Ring) leaveTarget(vn *LocalVnode) *Vnode {
+	for _, s := range vn.successors() {
+		if s != nil && s.Host != r.config.Hostname {
+			return s
+		}
+	}
+	return nil
+}
+
 // Shutdown shuts down the local processes in a given Chord ring
-// Blocks until all the vnodes terminate.
+// Blocks until all the vnodes terminate. If Config.GracefulShutdown
+// is set, the ring is left and keys are handed off first.
 func (r *Ring) Shutdown() {
+	if r.config.GracefulShutdown {
+		if err := r.Leave(); err != nil {
+			r.logger().Error("Failed to leave ring gracefully", "error", err)
+		}
+		return
+	}
+	r.stopWatches()
 	r.stopVnodes()
 	r.stopDelegate()
+	r.stopEvents()
+	if err := r.closeStores(); err != nil {
+		r.logger().Error("Failed to close vnode stores", "error", err)
+	}
 }
 
 // Does a key lookup for up to N successors of a key
@@ -214,11 +409,11 @@ func (r *Ring) Lookup(n int, key []byte) ([]*Vnode, error) {
 		return nil, fmt.Errorf("Cannot ask for more successors than NumSuccessors!")
 	}
 
-	// Hash the key
-	h := r.config.HashFunc()
-	h.Write(key)
-	key_hash := h.Sum(nil)
+	return r.lookupHash(n, r.hashKey(key))
+}
 
+// Does a lookup for up to N successors of a hash
+func (r *Ring) lookupHash(n int, key_hash []byte) ([]*Vnode, error) {
 	// Find the nearest local vnode
 	nearest := r.nearestVnode(key_hash)
 
@@ -229,7 +424,7 @@ func (r *Ring) Lookup(n int, key []byte) ([]*Vnode, error) {
 	}
 
 	// Trim the nil successors
-	for successors[len(successors)-1] == nil {
+	for len(successors) > 0 && successors[len(successors)-1] == nil {
 		successors = successors[:len(successors)-1]
 	}
 	return successors, nil
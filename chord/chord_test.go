@@ -2,12 +2,14 @@ package chord
 
 import (
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
 
 type MultiLocalTrans struct {
 	remote Transport
+	lock   sync.RWMutex
 	hosts  map[string]*LocalTransport
 }
 
@@ -20,7 +22,7 @@ func InitMLTransport() *MultiLocalTrans {
 }
 
 func (ml *MultiLocalTrans) ListVnodes(host string) ([]*Vnode, error) {
-	if local, ok := ml.hosts[host]; ok {
+	if local, ok := ml.local(host); ok {
 		return local.ListVnodes(host)
 	}
 	return ml.remote.ListVnodes(host)
@@ -28,7 +30,7 @@ func (ml *MultiLocalTrans) ListVnodes(host string) ([]*Vnode, error) {
 
 // Ping a Vnode, check for liveness
 func (ml *MultiLocalTrans) Ping(v *Vnode) (bool, error) {
-	if local, ok := ml.hosts[v.Host]; ok {
+	if local, ok := ml.local(v.Host); ok {
 		return local.Ping(v)
 	}
 	return ml.remote.Ping(v)
@@ -36,7 +38,7 @@ func (ml *MultiLocalTrans) Ping(v *Vnode) (bool, error) {
 
 // Request a nodes predecessor
 func (ml *MultiLocalTrans) GetPredecessor(v *Vnode) (*Vnode, error) {
-	if local, ok := ml.hosts[v.Host]; ok {
+	if local, ok := ml.local(v.Host); ok {
 		return local.GetPredecessor(v)
 	}
 	return ml.remote.GetPredecessor(v)
@@ -44,7 +46,7 @@ func (ml *MultiLocalTrans) GetPredecessor(v *Vnode) (*Vnode, error) {
 
 // Notify our successor of ourselves
 func (ml *MultiLocalTrans) Notify(target, self *Vnode) ([]*Vnode, error) {
-	if local, ok := ml.hosts[target.Host]; ok {
+	if local, ok := ml.local(target.Host); ok {
 		return local.Notify(target, self)
 	}
 	return ml.remote.Notify(target, self)
@@ -52,7 +54,7 @@ func (ml *MultiLocalTrans) Notify(target, self *Vnode) ([]*Vnode, error) {
 
 // Find a successor
 func (ml *MultiLocalTrans) FindSuccessors(v *Vnode, n int, k []byte) ([]*Vnode, error) {
-	if local, ok := ml.hosts[v.Host]; ok {
+	if local, ok := ml.local(v.Host); ok {
 		return local.FindSuccessors(v, n, k)
 	}
 	return ml.remote.FindSuccessors(v, n, k)
@@ -60,7 +62,7 @@ func (ml *MultiLocalTrans) FindSuccessors(v *Vnode, n int, k []byte) ([]*Vnode,
 
 // Clears a predecessor if it matches a given vnode. Used to leave.
 func (ml *MultiLocalTrans) ClearPredecessor(target, self *Vnode) error {
-	if local, ok := ml.hosts[target.Host]; ok {
+	if local, ok := ml.local(target.Host); ok {
 		return local.ClearPredecessor(target, self)
 	}
 	return ml.remote.ClearPredecessor(target, self)
@@ -68,25 +70,189 @@ func (ml *MultiLocalTrans) ClearPredecessor(target, self *Vnode) error {
 
 // Instructs a node to skip a given successor. Used to leave.
 func (ml *MultiLocalTrans) SkipSuccessor(target, self *Vnode) error {
-	if local, ok := ml.hosts[target.Host]; ok {
+	if local, ok := ml.local(target.Host); ok {
 		return local.SkipSuccessor(target, self)
 	}
 	return ml.remote.SkipSuccessor(target, self)
 }
 
+// Read the item stored under a key on a vnode
+func (ml *MultiLocalTrans) Get(target *Vnode, key []byte) (*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Get(target, key)
+	}
+	return ml.remote.Get(target, key)
+}
+
+// Store a versioned item on a vnode
+func (ml *MultiLocalTrans) Put(target *Vnode, item *Item) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Put(target, item)
+	}
+	return ml.remote.Put(target, item)
+}
+
+// Remove a key stored on a vnode
+func (ml *MultiLocalTrans) Delete(target *Vnode, key []byte) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Delete(target, key)
+	}
+	return ml.remote.Delete(target, key)
+}
+
+// Read items from vnodes of a host
+func (ml *MultiLocalTrans) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	if local, ok := ml.local(targets[0].Host); ok {
+		return local.MultiGet(targets, keys)
+	}
+	return ml.remote.MultiGet(targets, keys)
+}
+
+// Store items on vnodes of a host
+func (ml *MultiLocalTrans) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	if local, ok := ml.local(targets[0].Host); ok {
+		return local.MultiPut(targets, items)
+	}
+	return ml.remote.MultiPut(targets, items)
+}
+
+// Conditionally store an item on a vnode
+func (ml *MultiLocalTrans) CompareAndSwap(target *Vnode, item *Item, expected uint64, absent bool) (*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.CompareAndSwap(target, item, expected, absent)
+	}
+	return ml.remote.CompareAndSwap(target, item, expected, absent)
+}
+
+// Add to a counter on a vnode
+func (ml *MultiLocalTrans) Increment(target *Vnode, key []byte, delta int64) (*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Increment(target, key, delta)
+	}
+	return ml.remote.Increment(target, key, delta)
+}
+
+// Append to a value on a vnode
+func (ml *MultiLocalTrans) Append(target *Vnode, key, data []byte) (*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Append(target, key, data)
+	}
+	return ml.remote.Append(target, key, data)
+}
+
+// Watch a key on a vnode
+func (ml *MultiLocalTrans) Watch(target *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Watch(target, key, id, subscriber)
+	}
+	return ml.remote.Watch(target, key, id, subscriber)
+}
+
+// Stop watching a key on a vnode
+func (ml *MultiLocalTrans) Unwatch(target *Vnode, key []byte, id string) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Unwatch(target, key, id)
+	}
+	return ml.remote.Unwatch(target, key, id)
+}
+
+// Push a change to a watch
+func (ml *MultiLocalTrans) WatchEvent(target *Vnode, id string, ev *WatchEvent) (bool, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.WatchEvent(target, id, ev)
+	}
+	return ml.remote.WatchEvent(target, id, ev)
+}
+
+// Prepare a transaction on a vnode
+func (ml *MultiLocalTrans) TxnPrepare(target *Vnode, id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.TxnPrepare(target, id, coordinator, items, timeout)
+	}
+	return ml.remote.TxnPrepare(target, id, coordinator, items, timeout)
+}
+
+// Commit a transaction on a vnode
+func (ml *MultiLocalTrans) TxnCommit(target *Vnode, id string) ([]*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.TxnCommit(target, id)
+	}
+	return ml.remote.TxnCommit(target, id)
+}
+
+// Abort a transaction on a vnode
+func (ml *MultiLocalTrans) TxnAbort(target *Vnode, id string) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.TxnAbort(target, id)
+	}
+	return ml.remote.TxnAbort(target, id)
+}
+
+// Ask for the outcome of a transaction
+func (ml *MultiLocalTrans) TxnStatus(target *Vnode, id string) (TxnState, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.TxnStatus(target, id)
+	}
+	return ml.remote.TxnStatus(target, id)
+}
+
+// List items stored on a vnode
+func (ml *MultiLocalTrans) Scan(target *Vnode, start, end []byte, limit int) ([]*Item, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Scan(target, start, end, limit)
+	}
+	return ml.remote.Scan(target, start, end, limit)
+}
+
+// Hand over items to a vnode
+func (ml *MultiLocalTrans) Transfer(target *Vnode, items []*Item) error {
+	if local, ok := ml.local(target.Host); ok {
+		return local.Transfer(target, items)
+	}
+	return ml.remote.Transfer(target, items)
+}
+
+// Get the hashes of nodes of a vnode's Merkle tree
+func (ml *MultiLocalTrans) MerkleNodes(target *Vnode, start, end []byte, nodes []int) ([][]byte, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.MerkleNodes(target, start, end, nodes)
+	}
+	return ml.remote.MerkleNodes(target, start, end, nodes)
+}
+
+// Get the digests of a vnode's keys within Merkle tree leaves
+func (ml *MultiLocalTrans) KeyDigests(target *Vnode, start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	if local, ok := ml.local(target.Host); ok {
+		return local.KeyDigests(target, start, end, leaves)
+	}
+	return ml.remote.KeyDigests(target, start, end, leaves)
+}
+
 func (ml *MultiLocalTrans) Register(v *Vnode, o VnodeRPC) {
+	ml.lock.Lock()
 	local, ok := ml.hosts[v.Host]
 	if !ok {
 		local = InitLocalTransport(nil).(*LocalTransport)
 		ml.hosts[v.Host] = local
 	}
+	ml.lock.Unlock()
 	local.Register(v, o)
 }
 
 func (ml *MultiLocalTrans) Deregister(host string) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
 	delete(ml.hosts, host)
 }
 
+// Returns the transport of a host
+func (ml *MultiLocalTrans) local(host string) (*LocalTransport, bool) {
+	ml.lock.RLock()
+	defer ml.lock.RUnlock()
+	local, ok := ml.hosts[host]
+	return local, ok
+}
+
 func TestDefaultConfig(t *testing.T) {
 	conf := DefaultConfig("test")
 	if conf.Hostname != "test" {
@@ -101,7 +267,7 @@ func TestDefaultConfig(t *testing.T) {
 	if conf.HashFunc == nil {
 		t.Fatalf("bad hash")
 	}
-	if conf.hashBits != 160 {
+	if conf.HashBits != 160 {
 		t.Fatalf("bad hash bits")
 	}
 	if conf.StabilizeMin != time.Duration(15*time.Second) {
@@ -122,17 +288,42 @@ func fastConf() *Config {
 	return conf
 }
 
+// Waits up to a second for the running routines to drop to
+// at most n, returning the number left running
+func settleGoroutines(n int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		num := runtime.NumGoroutine()
+		if num <= n || time.Now().After(deadline) {
+			return num
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestCreateShutdown(t *testing.T) {
 	// Start the timer thread
 	time.After(15)
 	conf := fastConf()
+
+	// Let the routines of earlier tests exit before counting
 	numGo := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		time.Sleep(50 * time.Millisecond)
+		n := runtime.NumGoroutine()
+		if n == numGo {
+			break
+		}
+		numGo = n
+	}
 	r, err := Create(conf, nil)
 	if err != nil {
 		t.Fatalf("unexpected err. %s", err)
 	}
 	r.Shutdown()
-	after := runtime.NumGoroutine()
+
+	// Give the routines of the ring a moment to exit
+	after := settleGoroutines(numGo)
 	if after != numGo {
 		t.Fatalf("unexpected routines! A:%d B:%d", after, numGo)
 	}
@@ -204,11 +395,11 @@ func TestLeave(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 
 	// Verify r2 ring is still in tact
-	num := len(r2.vnodes)
-	for idx, vn := range r2.vnodes {
-		if vn.successors[0] != &r2.vnodes[(idx+1)%num].Vnode {
-			t.Fatalf("bad successor! Got:%s:%s", vn.successors[0].Host,
-				vn.successors[0])
+	num := len(r2.Vnodes)
+	for idx, vn := range r2.Vnodes {
+		succ := vn.successor()
+		if !succ.Equal(&r2.Vnodes[(idx+1)%num].Vnode) {
+			t.Fatalf("bad successor! Got:%s:%s", succ.Host, succ)
 		}
 	}
 }
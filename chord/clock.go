@@ -0,0 +1,175 @@
+package chord
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+// VectorClock tracks the causal history of a value, as the number of
+// writes coordinated by each actor. Each run of a ring is an actor of
+// its own, so its entries never go back after a restart.
+type VectorClock map[string]uint64
+
+// Copy returns an independent copy of the clock
+func (vc VectorClock) Copy() VectorClock {
+	out := make(VectorClock, len(vc))
+	for host, n := range vc {
+		out[host] = n
+	}
+	return out
+}
+
+// Increment advances the entry of an actor by one
+func (vc VectorClock) Increment(actor string) {
+	vc[actor]++
+}
+
+// Merge raises each entry to the maximum of both clocks
+func (vc VectorClock) Merge(other VectorClock) {
+	for host, n := range other {
+		if n > vc[host] {
+			vc[host] = n
+		}
+	}
+}
+
+// Descends checks if the clock has seen every write the other clock has
+func (vc VectorClock) Descends(other VectorClock) bool {
+	for host, n := range other {
+		if vc[host] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal checks if both clocks have seen exactly the same writes
+func (vc VectorClock) Equal(other VectorClock) bool {
+	return vc.Descends(other) && other.Descends(vc)
+}
+
+// Concurrent checks if neither clock has seen all the writes of the other
+func (vc VectorClock) Concurrent(other VectorClock) bool {
+	return !vc.Descends(other) && !other.Descends(vc)
+}
+
+// Returns the actor of a run of a ring on a host
+func newClockActor(host string) string {
+	var id [8]byte
+	rand.Read(id[:])
+	return fmt.Sprintf("%s/%x", host, id)
+}
+
+// Sets our entry of a clock for a new write. The entry counts the
+// writes coordinated by this run of the ring, so successive writes
+// through it supersede each other, even without a context token.
+func (r *Ring) tick(clock VectorClock) {
+	clock[r.clockActor] = r.clockSeq.Add(1)
+}
+
+// Version is a single value of a key, along with its clock
+type Version struct {
+	Value   []byte
+	Clock   VectorClock
+	Deleted time.Time // Time of the delete, if the version is a tombstone
+	Expires time.Time // Time the value expires, if written with a TTL
+}
+
+// Returns every version of an item, starting with its primary value
+func (i *Item) versions() []*Version {
+	vs := []*Version{{Value: i.Value, Clock: i.Clock, Deleted: i.Deleted, Expires: i.Expires}}
+	return append(vs, i.Siblings...)
+}
+
+// Returns a copy of the item holding the given versions. The first
+// becomes the primary value, and the rest its siblings.
+func (i *Item) withVersions(vs []*Version) *Item {
+	out := &Item{Key: i.Key, Hash: i.Hash, Value: vs[0].Value, Clock: vs[0].Clock,
+		Deleted: vs[0].Deleted, Expires: vs[0].Expires, Version: i.Version}
+	if len(vs) > 1 {
+		out.Siblings = vs[1:]
+	}
+	return out
+}
+
+// Reduces versions to those not superseded by any other, dropping
+// duplicates. The order of the remaining versions is kept.
+func reconcile(vs []*Version) []*Version {
+	var out []*Version
+	for idx, v := range vs {
+		keep := true
+		for jdx, other := range vs {
+			if idx == jdx {
+				continue
+			}
+			// Superseded, or a preferred copy of the same write
+			if other.Clock.Descends(v.Clock) &&
+				(!v.Clock.Descends(other.Clock) || preferCopy(other, v, jdx < idx)) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Chooses between two copies of the same write. A value that expired
+// into a tombstone replaces the value, otherwise the first copy is kept.
+func preferCopy(a, b *Version, aFirst bool) bool {
+	if a.tombstone() != b.tombstone() {
+		return a.tombstone()
+	}
+	return aFirst
+}
+
+// Merges the versions held by two copies of an item
+func mergeItems(a, b *Item) *Item {
+	out := a.withVersions(reconcile(append(a.versions(), b.versions()...)))
+	if b.Version > out.Version {
+		out.Version = b.Version
+	}
+	return out
+}
+
+// ConflictError is returned by Get when concurrent writes left several
+// siblings for a key. A Put using the context token resolves them.
+type ConflictError struct {
+	Key     []byte   // Key in conflict
+	Values  [][]byte // Value of each sibling
+	Context []byte   // Context token covering every sibling
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Key %q has %d conflicting values", e.Key, len(e.Values))
+}
+
+// Encodes the merged clock of a set of versions as an opaque context token
+func encodeContext(vs []*Version) ([]byte, error) {
+	clock := VectorClock{}
+	for _, v := range vs {
+		clock.Merge(v.Clock)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(clock); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a context token back into a clock
+func decodeContext(ctx []byte) (VectorClock, error) {
+	clock := VectorClock{}
+	if len(ctx) == 0 {
+		return clock, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(ctx)).Decode(&clock); err != nil {
+		return nil, fmt.Errorf("Invalid context token! Got %s", err)
+	}
+	return clock, nil
+}
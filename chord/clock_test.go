@@ -0,0 +1,122 @@
+package chord
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVectorClockCompare(t *testing.T) {
+	a := VectorClock{"a": 1}
+	b := a.Copy()
+	b.Increment("a")
+	if !b.Descends(a) || a.Descends(b) {
+		t.Fatalf("expected %v to descend %v", b, a)
+	}
+
+	c := a.Copy()
+	c.Increment("c")
+	if !b.Concurrent(c) {
+		t.Fatalf("expected %v and %v to be concurrent", b, c)
+	}
+
+	// Merging covers both
+	m := b.Copy()
+	m.Merge(c)
+	if !m.Descends(b) || !m.Descends(c) {
+		t.Fatalf("bad merge: %v", m)
+	}
+	if !m.Equal(m.Copy()) {
+		t.Fatalf("expected copy to be equal")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	old := &Version{Value: []byte("old"), Clock: VectorClock{"a": 1}}
+	newer := &Version{Value: []byte("new"), Clock: VectorClock{"a": 2}}
+	other := &Version{Value: []byte("other"), Clock: VectorClock{"a": 1, "b": 1}}
+	dup := &Version{Value: []byte("new"), Clock: VectorClock{"a": 2}}
+
+	vs := reconcile([]*Version{old, newer, other, dup})
+	if len(vs) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(vs))
+	}
+	if vs[0] != newer || vs[1] != other {
+		t.Fatalf("bad versions: %v", vs)
+	}
+}
+
+func TestMergeItems(t *testing.T) {
+	a := &Item{Key: []byte("foo"), Value: []byte("a"), Clock: VectorClock{"a": 1}}
+	b := &Item{Key: []byte("foo"), Value: []byte("b"), Clock: VectorClock{"b": 1}}
+
+	merged := mergeItems(a, b)
+	if len(merged.versions()) != 2 {
+		t.Fatalf("expected siblings, got %v", merged.versions())
+	}
+
+	// A write that has seen both resolves them
+	clock := VectorClock{"a": 1, "b": 1}
+	clock.Increment("a")
+	c := &Item{Key: []byte("foo"), Value: []byte("c"), Clock: clock}
+	merged = mergeItems(merged, c)
+	if len(merged.Siblings) != 0 || !bytes.Equal(merged.Value, []byte("c")) {
+		t.Fatalf("expected a single version, got %v", merged.versions())
+	}
+}
+
+func TestContextToken(t *testing.T) {
+	vs := []*Version{
+		{Clock: VectorClock{"a": 3}},
+		{Clock: VectorClock{"a": 1, "b": 2}},
+	}
+	ctx, err := encodeContext(vs)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	clock, err := decodeContext(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !clock.Equal(VectorClock{"a": 3, "b": 2}) {
+		t.Fatalf("bad clock: %v", clock)
+	}
+	if _, err := decodeContext([]byte("junk")); err == nil {
+		t.Fatalf("expected err!")
+	}
+}
+
+func TestRingClockCounts(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Our entry counts the writes we coordinate
+	key := []byte("foo")
+	for _, val := range []string{"a", "b"} {
+		if err := r.Put(key, []byte(val)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	item, err := r.read(key, r.options(nil))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("b")) || len(item.Siblings) != 0 {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if !item.Clock.Equal(VectorClock{r.clockActor: 2}) {
+		t.Fatalf("bad clock: %v", item.Clock)
+	}
+
+	// Another run on the same host is another actor
+	r2, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r2.Shutdown()
+	if r2.clockActor == r.clockActor {
+		t.Fatalf("expected a distinct actor, got %s", r2.clockActor)
+	}
+}
@@ -0,0 +1,83 @@
+package chord
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// ErrNotCounter is returned by Increment when the value stored
+// under a key is not a decimal integer
+var ErrNotCounter = errors.New("Value is not a counter")
+
+// ErrCounterOverflow is returned by Increment when the new count
+// does not fit in an int64
+var ErrCounterOverflow = errors.New("Counter overflows")
+
+// Increment adds delta to the counter stored under a key, returning
+// the new count. Counters are stored as decimal integers, and a key
+// without a value counts from 0. The addition happens atomically on
+// the vnode owning the key, which then replicates the result. Returns
+// ErrKeyLocked while a transaction is writing the key, and
+// ErrCounterOverflow, leaving the counter as it is, if the count would
+// overflow. If the owner added delta but the result was not replicated
+// to the write quorum, the new count is returned along with a
+// *ReplicationError, and the Increment must not be retried.
+func (r *Ring) Increment(key []byte, delta int64, opts ...Option) (int64, error) {
+	item, err := r.ownerWrite(key, r.options(opts), func(owner *Vnode) (*Item, error) {
+		return r.transport.Increment(owner, key, delta)
+	})
+	if item == nil {
+		return 0, err
+	}
+	n, perr := strconv.ParseInt(string(item.Value), 10, 64)
+	if perr != nil {
+		return 0, perr
+	}
+	return n, err
+}
+
+// Append adds data to the end of the value stored under a key, or
+// stores it if the key has no value. Like Increment, it happens
+// atomically on the vnode owning the key, and returns a
+// *ReplicationError, to not be retried, if the owner appended the
+// data but the result was not replicated to the write quorum.
+func (r *Ring) Append(key, data []byte, opts ...Option) error {
+	_, err := r.ownerWrite(key, r.options(opts), func(owner *Vnode) (*Item, error) {
+		return r.transport.Append(owner, key, data)
+	})
+	return err
+}
+
+// RPC: Adds delta to the counter stored under a key
+func (vn *LocalVnode) Increment(key []byte, delta int64) (*Item, error) {
+	return vn.update(key, func(cur *Version, version uint64) (*Version, error) {
+		var n int64
+		next := &Version{}
+		if cur != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(cur.Value), 10, 64); err != nil {
+				return nil, ErrNotCounter
+			}
+			next.Expires = cur.Expires
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, ErrCounterOverflow
+		}
+		next.Value = []byte(strconv.FormatInt(n+delta, 10))
+		return next, nil
+	})
+}
+
+// RPC: Adds data to the end of the value stored under a key
+func (vn *LocalVnode) Append(key, data []byte) (*Item, error) {
+	return vn.update(key, func(cur *Version, version uint64) (*Version, error) {
+		next := &Version{}
+		if cur != nil {
+			next.Value = append(next.Value, cur.Value...)
+			next.Expires = cur.Expires
+		}
+		next.Value = append(next.Value, data...)
+		return next, nil
+	})
+}
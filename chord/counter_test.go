@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingIncrement(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("counter")
+	if n, err := r.Increment(key, 5); err != nil || n != 5 {
+		t.Fatalf("unexpected result. %d %v", n, err)
+	}
+	if n, err := r.Increment(key, -2); err != nil || n != 3 {
+		t.Fatalf("unexpected result. %d %v", n, err)
+	}
+	val, ver, err := r.GetWithVersion(key)
+	if err != nil || ver != 2 || !bytes.Equal(val, []byte("3")) {
+		t.Fatalf("unexpected result. %s %d %v", val, ver, err)
+	}
+
+	// Only integers can be incremented
+	r.Put([]byte("foo"), []byte("bar"))
+	if _, err := r.Increment([]byte("foo"), 1); err != ErrNotCounter {
+		t.Fatalf("expected not counter, got %v", err)
+	}
+
+	// Counts that overflow are refused, leaving the counter as it was
+	if _, err := r.Increment(key, math.MaxInt64); err != ErrCounterOverflow {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+	if n, err := r.Increment(key, math.MinInt64); err != nil || n != math.MinInt64+3 {
+		t.Fatalf("unexpected result. %d %v", n, err)
+	}
+	if _, err := r.Increment(key, -4); err != ErrCounterOverflow {
+		t.Fatalf("expected overflow, got %v", err)
+	}
+	val, _, err = r.GetWithVersion(key)
+	if err != nil || !bytes.Equal(val, []byte(strconv.FormatInt(math.MinInt64+3, 10))) {
+		t.Fatalf("unexpected result. %s %v", val, err)
+	}
+}
+
+func TestRingIncrementUnderReplicated(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// The replica cannot be written, but the owner adds the delta once
+	key, _ := keyOwnedBy(t, r, "test")
+	ft.fail("test2")
+	n, err := r.Increment(key, 5, WithWriteQuorum(2))
+	rerr, ok := err.(*ReplicationError)
+	if !ok || n != 5 || rerr.Item.Version != 1 || !bytes.Equal(rerr.Item.Value, []byte("5")) {
+		t.Fatalf("expected replication error, got %d %v", n, err)
+	}
+	if err := r.Append(key, []byte("0"), WithWriteQuorum(2)); err == nil {
+		t.Fatalf("expected err!")
+	} else if rerr, ok := err.(*ReplicationError); !ok || !bytes.Equal(rerr.Item.Value, []byte("50")) {
+		t.Fatalf("expected replication error, got %v", err)
+	}
+	val, ver, err := r.GetWithVersion(key)
+	if err != nil || ver != 2 || !bytes.Equal(val, []byte("50")) {
+		t.Fatalf("unexpected result. %s %d %v", val, ver, err)
+	}
+}
+
+func TestRingAppend(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("log")
+	for _, data := range []string{"a", "b", "c"} {
+		if err := r.Append(key, []byte(data)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	val, err := r.Get(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("abc")) {
+		t.Fatalf("bad value: %s", val)
+	}
+}
+
+func TestRingIncrementConcurrent(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Increment through both rings at once, without retries
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for _, ring := range []*Ring{r, r2, r, r2} {
+		wg.Add(1)
+		go func(ring *Ring) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := ring.Increment(key, 1, WithWriteQuorum(2)); err != nil {
+					t.Errorf("unexpected err. %s", err)
+					return
+				}
+			}
+		}(ring)
+	}
+	wg.Wait()
+
+	val, err := r2.Get(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("100")) {
+		t.Fatalf("bad counter: %s", val)
+	}
+}
+
+func TestTCPIncrement(t *testing.T) {
+	listen := "localhost:10043"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	vn := &r.Vnodes[0].Vnode
+	item, err := trans.Increment(vn, []byte("foo"), 2)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("2")) {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+	if _, err := trans.Append(vn, []byte("foo"), []byte("x")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := trans.Increment(vn, []byte("foo"), 1); err != ErrNotCounter {
+		t.Fatalf("expected not counter, got %v", err)
+	}
+}
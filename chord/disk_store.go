@@ -0,0 +1,404 @@
+package chord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when a DiskStore flushes its log to disk
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // fsync after every write
+	SyncPeriodic                   // fsync every Config.SyncInterval
+	SyncNever                      // leave flushing to the OS
+)
+
+const (
+	walFile      = "wal"
+	snapshotFile = "snapshot"
+
+	// Number of log records before compaction, if not configured
+	defaultCompactThreshold = 1024
+
+	// Frames claiming to be larger than this are treated as corrupt
+	maxRecordSize = 256 * 1024 * 1024
+)
+
+// Log record operations
+const (
+	walPut = iota
+	walDelete
+)
+
+// The write-ahead log of a DiskStore, an *os.File outside of tests
+type logFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// A single record in the write-ahead log or snapshot
+type walRecord struct {
+	Op   int
+	Item *Item
+	Key  []byte
+}
+
+/*
+DiskStore is a durable Store. Every write is appended to a write-ahead
+log before being applied to an in-memory copy used to serve reads. Once
+the log grows past Config.CompactThreshold records, the items are written
+out to a snapshot and the log is truncated.
+
+Records are framed with their length and a CRC32, so a torn write at the
+tail of the log is detected and discarded when the store is reopened. A
+write that fails is dropped from the log right away, so the writes
+after it are not lost.
+*/
+type DiskStore struct {
+	dir       string
+	policy    SyncPolicy
+	compactAt int
+	logger    Logger
+
+	lock    sync.Mutex
+	mem     *MemoryStore
+	wal     logFile
+	size    int64 // Offset after the last complete record in the log
+	failed  error // Set once a torn record could not be dropped
+	records int
+	dirty   bool
+	closed  bool
+	stopCh  chan struct{}
+}
+
+// Opens the DiskStore kept in a directory, creating it if needed and
+// replaying any existing snapshot and log. The sync and compaction
+// settings are taken from the config.
+func OpenDiskStore(dir string, conf *Config) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskStore{
+		dir:       dir,
+		policy:    conf.SyncPolicy,
+		compactAt: conf.CompactThreshold,
+		logger:    configLogger(conf),
+		mem:       NewMemoryStore(),
+		stopCh:    make(chan struct{}),
+	}
+	if d.compactAt <= 0 {
+		d.compactAt = defaultCompactThreshold
+	}
+
+	// Load the snapshot, then replay the log on top of it
+	if _, err := d.replay(filepath.Join(dir, snapshotFile)); err != nil {
+		return nil, fmt.Errorf("Failed to read snapshot! Got %s", err)
+	}
+	d.records = 0
+	walPath := filepath.Join(dir, walFile)
+	valid, err := d.replay(walPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to replay log! Got %s", err)
+	}
+
+	// Open the log for appending, dropping any torn tail
+	wal, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := wal.Truncate(valid); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	if _, err := wal.Seek(valid, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	d.wal, d.size = wal, valid
+
+	// Flush in the background if requested
+	if d.policy == SyncPeriodic {
+		interval := conf.SyncInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		go d.syncLoop(interval)
+	}
+	return d, nil
+}
+
+// Returns a StoreFunc that opens a DiskStore for each vnode in a
+// subdirectory of dir named by the vnode ID
+func DiskStoreFunc(dir string, conf *Config) StoreFunc {
+	return func(vn *Vnode) (Store, error) {
+		return OpenDiskStore(filepath.Join(dir, fmt.Sprintf("%x", vn.Id)), conf)
+	}
+}
+
+func (d *DiskStore) Get(key []byte) (*Item, error) {
+	return d.mem.Get(key)
+}
+
+func (d *DiskStore) Put(item *Item) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.append(&walRecord{Op: walPut, Item: item}); err != nil {
+		return err
+	}
+	d.mem.Put(item)
+	return d.maybeCompact()
+}
+
+func (d *DiskStore) Delete(key []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.append(&walRecord{Op: walDelete, Key: key}); err != nil {
+		return err
+	}
+	d.mem.Delete(key)
+	return d.maybeCompact()
+}
+
+func (d *DiskStore) Range(start, end []byte, fn func(*Item) bool) error {
+	return d.mem.Range(start, end, fn)
+}
+
+// Close flushes the log and releases the files held by the store
+func (d *DiskStore) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	close(d.stopCh)
+	err := d.wal.Sync()
+	return mergeErrors(err, d.wal.Close())
+}
+
+// Compact writes all items out to a new snapshot and truncates the log
+func (d *DiskStore) Compact() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.compact()
+}
+
+// Appends a record to the log. Must be called with the lock held.
+func (d *DiskStore) append(rec *walRecord) error {
+	if d.closed {
+		return fmt.Errorf("Store is closed")
+	}
+	if d.failed != nil {
+		return fmt.Errorf("Store failed! Got %s", d.failed)
+	}
+	frame, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	_, err = d.wal.Write(frame)
+	if err == nil && d.policy == SyncAlways {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		d.dropTail(err)
+		return err
+	}
+	d.size += int64(len(frame))
+	d.records++
+	if d.policy != SyncAlways {
+		d.dirty = true
+	}
+	return nil
+}
+
+// Drops a record that failed to be written from the end of the log,
+// since replay would stop at it and skip the records written after it.
+// If it cannot be dropped, further writes are refused. Must be called
+// with the lock held.
+func (d *DiskStore) dropTail(cause error) {
+	err := d.wal.Truncate(d.size)
+	if err == nil {
+		_, err = d.wal.Seek(d.size, io.SeekStart)
+	}
+	if err != nil {
+		d.failed = mergeErrors(cause, err)
+		d.logger.Error("Failed to drop torn record, refusing writes", "dir", d.dir, "error", d.failed)
+	}
+}
+
+// Compacts the log if it has grown too large. The write that triggered
+// it is already durable, so a failure is only logged and retried on
+// the next write. Must be called with the lock held.
+func (d *DiskStore) maybeCompact() error {
+	if d.records < d.compactAt {
+		return nil
+	}
+	if err := d.compact(); err != nil {
+		d.logger.Error("Failed to compact store", "dir", d.dir, "error", err)
+	}
+	return nil
+}
+
+// Writes a snapshot and truncates the log. Must be called with the lock held.
+func (d *DiskStore) compact() error {
+	if d.closed {
+		return fmt.Errorf("Store is closed")
+	}
+
+	// Write the snapshot to a temporary file
+	tmpPath := filepath.Join(d.dir, snapshotFile+".tmp")
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(tmp)
+	d.mem.Range(nil, nil, func(item *Item) bool {
+		var frame []byte
+		frame, err = encodeRecord(&walRecord{Op: walPut, Item: item})
+		if err == nil {
+			_, err = buf.Write(frame)
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = mergeErrors(err, tmp.Close())
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Swap in the new snapshot. The old log is still valid on top
+	// of it until truncated, since replaying records is idempotent.
+	if err := os.Rename(tmpPath, filepath.Join(d.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+
+	// Truncate the log
+	if err := d.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d.size = 0
+	d.records = 0
+	d.dirty = false
+	return d.wal.Sync()
+}
+
+// Applies the records in a file, returning the offset after
+// the last valid record. A missing file is treated as empty.
+func (d *DiskStore) replay(path string) (int64, error) {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	r := bufio.NewReader(fh)
+	var offset int64
+	for {
+		rec, n, err := decodeRecord(r)
+		if err != nil {
+			// Stop at the end of the file or a torn record
+			return offset, nil
+		}
+		switch rec.Op {
+		case walPut:
+			d.mem.Put(rec.Item)
+		case walDelete:
+			d.mem.Delete(rec.Key)
+		}
+		offset += int64(n)
+		d.records++
+	}
+}
+
+// Periodically flushes the log until the store is closed
+func (d *DiskStore) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.lock.Lock()
+			if d.dirty && !d.closed {
+				d.wal.Sync()
+				d.dirty = false
+			}
+			d.lock.Unlock()
+		case <-d.stopCh:
+			return
+		}
+	}
+}
+
+// Encodes a record as a length and checksum prefixed frame
+func encodeRecord(rec *walRecord) ([]byte, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(rec); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 8+body.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(body.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(body.Bytes()))
+	copy(frame[8:], body.Bytes())
+	return frame, nil
+}
+
+// Decodes the next frame, returning the record and frame size
+func decodeRecord(r io.Reader) (*walRecord, int, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(head[0:4])
+	if size > maxRecordSize {
+		return nil, 0, fmt.Errorf("Record too large")
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(head[4:8]) {
+		return nil, 0, fmt.Errorf("Checksum mismatch")
+	}
+	rec := &walRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(rec); err != nil {
+		return nil, 0, err
+	}
+	return rec, len(head) + len(body), nil
+}
+
+// Flushes a directory so that renames within it are durable
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fh.Sync()
+	return mergeErrors(err, fh.Close())
+}
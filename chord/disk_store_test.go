@@ -0,0 +1,216 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "chord")
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	return dir
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig("test")
+
+	d, err := OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := d.Put(&Item{Key: key, Hash: []byte{byte(i)}, Value: key}); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	if err := d.Delete([]byte("key3")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Reopen and check the contents survived
+	d, err = OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer d.Close()
+	if _, err := d.Get([]byte("key3")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	item, err := d.Get([]byte("key7"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("key7")) {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+}
+
+func TestDiskStoreCompact(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig("test")
+	conf.CompactThreshold = 4
+
+	d, err := OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		d.Put(&Item{Key: key, Hash: []byte{byte(i)}, Value: key})
+	}
+	if d.records >= 4 {
+		t.Fatalf("expected log to be compacted, has %d records", d.records)
+	}
+	d.Close()
+
+	d, err = OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer d.Close()
+	var n int
+	d.Range(nil, nil, func(*Item) bool {
+		n++
+		return true
+	})
+	if n != 10 {
+		t.Fatalf("expected 10 items, got %d", n)
+	}
+}
+
+func TestDiskStoreTornWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig("test")
+
+	d, err := OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	d.Put(&Item{Key: []byte("foo"), Hash: []byte{1}, Value: []byte("bar")})
+	d.Close()
+
+	// Simulate a crash in the middle of a write
+	fh, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+	fh.Write([]byte{0, 0, 1, 0, 1, 2})
+	fh.Close()
+
+	d, err = OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := d.Get([]byte("foo")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// New writes must land after the last valid record
+	d.Put(&Item{Key: []byte("baz"), Hash: []byte{2}, Value: []byte("qux")})
+	d.Close()
+	d, err = OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer d.Close()
+	if _, err := d.Get([]byte("baz")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+}
+
+func TestRingRestartKeepsData(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	conf := fastConf()
+	conf.DataDir = dir
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := r.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Shutdown()
+
+	// Recreate the ring from the same directory
+	r, err = Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	val, err := r.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("bad value: %s", val)
+	}
+}
+
+// Log file whose next write stops halfway and fails
+type tornLogFile struct {
+	logFile
+	tear bool
+}
+
+func (f *tornLogFile) Write(p []byte) (int, error) {
+	if f.tear {
+		f.tear = false
+		n, _ := f.logFile.Write(p[:len(p)/2])
+		return n, fmt.Errorf("short write")
+	}
+	return f.logFile.Write(p)
+}
+
+func TestDiskStoreFailedWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig("test")
+
+	d, err := OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	wal := &tornLogFile{logFile: d.wal}
+	d.wal = wal
+	if err := d.Put(&Item{Key: []byte("a"), Hash: []byte{1}, Value: []byte("a")}); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	wal.tear = true
+	if err := d.Put(&Item{Key: []byte("b"), Hash: []byte{2}, Value: []byte("b")}); err == nil {
+		t.Fatalf("expected err!")
+	}
+	if err := d.Put(&Item{Key: []byte("c"), Hash: []byte{3}, Value: []byte("c")}); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// The writes after the failed one survive a reopen
+	d, err = OpenDiskStore(dir, conf)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer d.Close()
+	for _, key := range []string{"a", "c"} {
+		if _, err := d.Get([]byte(key)); err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+	}
+	if _, err := d.Get([]byte("b")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"sync"
+)
+
+// Number of events buffered for a subscription by default
+const eventBuffer = 64
+
+// EventType identifies a change observed by a local vnode
+type EventType int
+
+const (
+	EventSuccessorChanged   EventType = iota // Our first successor changed
+	EventPredecessorChanged                  // Our predecessor changed or was cleared
+	EventFingerUpdated                       // A finger table entry points to a new vnode
+	EventVnodeJoined                         // A vnode joined between our predecessor and us
+	EventVnodeLeft                           // A neighbor, or the local vnode, left the ring
+	EventStabilizeFailed                     // A stabilization step failed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSuccessorChanged:
+		return "SuccessorChanged"
+	case EventPredecessorChanged:
+		return "PredecessorChanged"
+	case EventFingerUpdated:
+		return "FingerUpdated"
+	case EventVnodeJoined:
+		return "VnodeJoined"
+	case EventVnodeLeft:
+		return "VnodeLeft"
+	case EventStabilizeFailed:
+		return "StabilizeFailed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event describes a change observed by a local vnode
+type Event struct {
+	Type   EventType
+	Local  *Vnode // Local vnode observing the change
+	Old    *Vnode // Previous neighbor or finger, or the vnode that left
+	New    *Vnode // New neighbor or finger, or the vnode that joined
+	Finger int    // Index of the finger, for EventFingerUpdated
+	Err    error  // Cause of the failure, for EventStabilizeFailed
+}
+
+// DropPolicy decides which events are lost when a subscriber
+// does not keep up with its buffer
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota // Drop buffered events to make room for new ones
+	DropNewest                   // Drop new events until there is room
+)
+
+// EventOption adjusts a subscription to the ring events
+type EventOption func(*eventOptions)
+
+// Settings of a subscription
+type eventOptions struct {
+	buffer int
+	policy DropPolicy
+}
+
+// WithEventBuffer sets the number of events buffered for the subscriber
+func WithEventBuffer(n int) EventOption {
+	return func(o *eventOptions) {
+		o.buffer = n
+	}
+}
+
+// WithDropPolicy sets which events are dropped once the buffer is
+// full, DropOldest by default
+func WithDropPolicy(p DropPolicy) EventOption {
+	return func(o *eventOptions) {
+		o.policy = p
+	}
+}
+
+/*
+Subscription delivers the events of a ring. Events are published
+without ever blocking the vnodes, so a subscriber that does not read
+fast enough loses events according to its DropPolicy, and Dropped
+counts them. Unlike the Delegate, a slow subscriber does not hold up
+the ring or the other subscribers.
+*/
+type Subscription struct {
+	C <-chan *Event // Events, closed once the subscription is stopped
+
+	ring   *Ring
+	ch     chan *Event
+	policy DropPolicy
+
+	lock    sync.Mutex
+	dropped uint64
+	stopped bool
+}
+
+// Events subscribes to the events of the local vnodes. Only events
+// happening after Events returns are delivered.
+func (r *Ring) Events(opts ...EventOption) *Subscription {
+	o := &eventOptions{buffer: eventBuffer}
+	for _, opt := range opts {
+		opt(o)
+	}
+	ch := make(chan *Event, max(o.buffer, 1))
+	s := &Subscription{C: ch, ring: r, ch: ch, policy: o.policy}
+
+	r.eventLock.Lock()
+	if r.subscriptions == nil {
+		r.subscriptions = make(map[*Subscription]struct{})
+	}
+	r.subscriptions[s] = struct{}{}
+	r.eventLock.Unlock()
+	return s
+}
+
+// Stop ends the subscription and closes C
+func (s *Subscription) Stop() {
+	s.ring.eventLock.Lock()
+	delete(s.ring.subscriptions, s)
+	s.ring.eventLock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.ch)
+	}
+}
+
+// Dropped returns the number of events the subscriber missed
+func (s *Subscription) Dropped() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+// Queues an event, applying the drop policy if the buffer is full
+func (s *Subscription) send(ev *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return
+	}
+	for {
+		select {
+		case s.ch <- ev:
+			return
+		default:
+		}
+		if s.policy == DropNewest {
+			s.dropped++
+			return
+		}
+		select {
+		case <-s.ch:
+			s.dropped++
+		default:
+		}
+	}
+}
+
+// Publishes an event to every subscriber, never blocking
+func (r *Ring) emit(ev *Event) {
+	r.eventLock.RLock()
+	defer r.eventLock.RUnlock()
+	for s := range r.subscriptions {
+		s.send(ev)
+	}
+}
+
+// Stops every subscription, once the ring is shut down
+func (r *Ring) stopEvents() {
+	r.eventLock.Lock()
+	subs := make([]*Subscription, 0, len(r.subscriptions))
+	for s := range r.subscriptions {
+		subs = append(subs, s)
+	}
+	r.eventLock.Unlock()
+	for _, s := range subs {
+		s.Stop()
+	}
+}
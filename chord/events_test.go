@@ -0,0 +1,117 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+// Waits for an event of a type matching the filter
+func waitEvent(t *testing.T, s *Subscription, typ EventType, match func(*Event) bool) *Event {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				t.Fatalf("subscription closed waiting for %s", typ)
+			}
+			if ev.Type == typ && (match == nil || match(ev)) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", typ)
+		}
+	}
+}
+
+func TestRingEvents(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	sub := r.Events()
+
+	// Another host joining becomes our neighbor
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	fromTest2 := func(ev *Event) bool {
+		return ev.New != nil && ev.New.Host == "test2"
+	}
+	waitEvent(t, sub, EventVnodeJoined, fromTest2)
+	waitEvent(t, sub, EventPredecessorChanged, fromTest2)
+	waitEvent(t, sub, EventSuccessorChanged, fromTest2)
+	waitEvent(t, sub, EventFingerUpdated, fromTest2)
+
+	// And leaving is noticed
+	if err := r2.Leave(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	waitEvent(t, sub, EventVnodeLeft, func(ev *Event) bool {
+		return ev.Old.Host == "test2"
+	})
+
+	// Shutting down closes the subscription
+	r.Shutdown()
+	for range sub.C {
+	}
+}
+
+func TestRingEventsStabilizeFailed(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	sub := r.Events()
+
+	// Point a vnode at a successor that cannot be reached
+	vn := r.Vnodes[0]
+	dead := &Vnode{Id: []byte{1}, Host: "dead"}
+	vn.lock.Lock()
+	for i := range vn.Successors {
+		vn.Successors[i] = nil
+	}
+	vn.Successors[0] = dead
+	vn.lock.Unlock()
+
+	// Other vnodes may fail to sync with it too
+	ev := waitEvent(t, sub, EventStabilizeFailed, func(ev *Event) bool {
+		return ev.Local.Equal(&vn.Vnode)
+	})
+	if ev.Err == nil {
+		t.Fatalf("bad event: %+v", ev)
+	}
+}
+
+func TestRingEventsDropPolicy(t *testing.T) {
+	// No vnodes, so only our events are published
+	r := &Ring{}
+	oldest := r.Events(WithEventBuffer(2))
+	newest := r.Events(WithEventBuffer(2), WithDropPolicy(DropNewest))
+	stopped := r.Events()
+	stopped.Stop()
+
+	// Publish more events than the buffers hold
+	for i := 0; i < 3; i++ {
+		r.emit(&Event{Type: EventFingerUpdated, Finger: i})
+	}
+	for _, tc := range []struct {
+		sub   *Subscription
+		first int
+	}{{oldest, 1}, {newest, 0}} {
+		if n := tc.sub.Dropped(); n != 1 {
+			t.Fatalf("expected a dropped event, got %d", n)
+		}
+		for i := tc.first; i < tc.first+2; i++ {
+			if ev := <-tc.sub.C; ev.Finger != i {
+				t.Fatalf("expected finger %d, got %d", i, ev.Finger)
+			}
+		}
+	}
+	if _, ok := <-stopped.C; ok {
+		t.Fatalf("expected closed subscription")
+	}
+}
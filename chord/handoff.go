@@ -0,0 +1,285 @@
+package chord
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Maximum number of items sent in a single transfer
+	transferBatchSize = 128
+
+	// Time to wait before retrying a failed transfer while leaving
+	leaveRetryInterval = 50 * time.Millisecond
+
+	// Time allowed to hand off keys when leaving, if not configured
+	defaultLeaveTimeout = 30 * time.Second
+)
+
+// Returned by a vnode asked to store keys after it has left the ring
+var errVnodeLeft = errors.New("Vnode has left the ring")
+
+// HandoffError is returned when leaving the ring if some keys
+// could not be handed off before the leave timeout expired
+type HandoffError struct {
+	Keys [][]byte // Keys that are still only held locally
+	Err  error    // Cause of the last failure
+}
+
+func (e *HandoffError) Error() string {
+	return fmt.Sprintf("Failed to hand off %d keys! Got %s", len(e.Keys), e.Err)
+}
+
+// A pending transfer of the hash range (Start, Target.Id] to the
+// vnode that took it over as our new predecessor
+type handoff struct {
+	Start  []byte
+	Target *Vnode
+	Lookup bool // Route each key to its owner instead of Target
+}
+
+// Queues a handoff when our predecessor changes from old to pred.
+// Without a previous predecessor the range given up is not known, so
+// everything outside of (pred, self] is routed to its current owner.
+// The lock must be held.
+func (vn *LocalVnode) queueHandoff(old, pred *Vnode) {
+	if pred == nil {
+		return
+	}
+	if old == nil {
+		vn.handoffs = append(vn.handoffs, &handoff{Start: vn.Id, Target: pred, Lookup: true})
+		return
+	}
+	if bytes.Equal(old.Id, pred.Id) {
+		return
+	}
+	vn.handoffs = append(vn.handoffs, &handoff{Start: old.Id, Target: pred})
+}
+
+// Works through the queued handoffs in order. Each range is moved in
+// batches that are only removed locally once acknowledged, so an
+// interrupted handoff resumes from where it stopped on the next call.
+func (vn *LocalVnode) transferHandoffs() error {
+	trans := vn.Ring.transport
+	for {
+		// Handoffs are only removed here, so the first stays in place
+		vn.lock.RLock()
+		if len(vn.handoffs) == 0 {
+			vn.lock.RUnlock()
+			return nil
+		}
+		h := vn.handoffs[0]
+		vn.lock.RUnlock()
+
+		var err error
+		if h.Lookup {
+			err = vn.rehomeRange(h.Start, h.Target.Id)
+		} else {
+			err = vn.transferRange(h.Start, h.Target.Id, h.Target)
+		}
+		if err != nil {
+			// Retry later unless the target is gone for good
+			if alive, _ := trans.Ping(h.Target); alive {
+				return err
+			}
+
+			// A later predecessor took over the range, otherwise
+			// the keys fall back to us
+			vn.lock.Lock()
+			if len(vn.handoffs) > 1 {
+				vn.handoffs[1].Start = h.Start
+			}
+			vn.lock.Unlock()
+		}
+		vn.lock.Lock()
+		vn.handoffs = vn.handoffs[1:]
+		vn.lock.Unlock()
+	}
+}
+
+// Moves all the items in (start, end] to the target vnode
+func (vn *LocalVnode) transferRange(start, end []byte, target *Vnode) error {
+	if bytes.Equal(start, end) {
+		return nil
+	}
+
+	// We may remain a replica of some of the keys, but only the
+	// replica set can tell which ones. Without replicas, routing
+	// may not know the target yet and name us the owner.
+	replicated := replicationFactor(vn.Ring.config) > 1
+	return vn.sendItems(target, vn.collectRange(start, end, 0), func(item *Item) bool {
+		return replicated && vn.isReplica(item.Hash)
+	})
+}
+
+// Sends items to a vnode in batches. Once acknowledged, the
+// items are removed locally unless keep returns true.
+func (vn *LocalVnode) sendItems(target *Vnode, items []*Item, keep func(*Item) bool) error {
+	for len(items) > 0 {
+		n := min(len(items), transferBatchSize)
+		if err := vn.Ring.transport.Transfer(target, items[:n]); err != nil {
+			return err
+		}
+		for _, item := range items[:n] {
+			if !keep(item) {
+				if err := vn.deleteSent(item); err != nil {
+					return err
+				}
+			}
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+// Removes an item once it was handed off, unless it was
+// written since then and the newer versions must be sent too
+func (vn *LocalVnode) deleteSent(item *Item) error {
+	vn.writeLock.Lock()
+	defer vn.writeLock.Unlock()
+	cur, err := vn.Store.Get(item.Key)
+	if err == ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	hf := vn.Ring.config.HashFunc
+	if !bytes.Equal(itemDigest(hf, cur).Digest, itemDigest(hf, item).Digest) {
+		return nil
+	}
+	return vn.Store.Delete(item.Key)
+}
+
+// Checks if we are one of the replicas of a hash. Assumes we are
+// if the replicas cannot be determined, so that no data is dropped.
+func (vn *LocalVnode) isReplica(hash []byte) bool {
+	replicas, err := vn.Ring.replicasForHash(hash)
+	if err != nil {
+		return true
+	}
+	for _, r := range replicas {
+		if r.Equal(&vn.Vnode) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hands off every item we hold to the target, retrying failed
+// batches until the deadline. Returns the keys left behind, if any.
+func (vn *LocalVnode) drain(target *Vnode, deadline time.Time) *HandoffError {
+	var err error
+	for {
+		batch := vn.collectRange(vn.Id, vn.Id, transferBatchSize)
+		if len(batch) == 0 {
+			return nil
+		}
+		if target == nil {
+			err = fmt.Errorf("No successor to hand off to")
+			break
+		}
+		if time.Now().After(deadline) {
+			err = mergeErrors(fmt.Errorf("Timed out handing off keys"), err)
+			break
+		}
+
+		// Send the batch, only removing items once acknowledged
+		if err = vn.Ring.transport.Transfer(target, batch); err != nil {
+			time.Sleep(leaveRetryInterval)
+			continue
+		}
+		for _, item := range batch {
+			if err = vn.deleteSent(item); err != nil {
+				break
+			}
+		}
+	}
+
+	// Report the keys we could not hand off
+	herr := &HandoffError{Err: err}
+	for _, item := range vn.collectRange(vn.Id, vn.Id, 0) {
+		herr.Keys = append(herr.Keys, item.Key)
+	}
+	return herr
+}
+
+// Returns the configured leave timeout
+func leaveTimeout(conf *Config) time.Duration {
+	if conf.LeaveTimeout <= 0 {
+		return defaultLeaveTimeout
+	}
+	return conf.LeaveTimeout
+}
+
+// Moves each item in (start, end] to the vnode that currently owns it
+func (vn *LocalVnode) rehomeRange(start, end []byte) error {
+	if bytes.Equal(start, end) {
+		return nil
+	}
+
+	// Group the items by owner
+	owners := make(map[string]*Vnode)
+	batches := make(map[string][]*Item)
+	var err error
+	for _, item := range vn.collectRange(start, end, 0) {
+		succs, lookupErr := vn.FindSuccessors(1, item.Hash)
+		if lookupErr != nil || len(succs) == 0 || succs[0] == nil {
+			err = mergeErrors(err, lookupErr)
+			continue
+		}
+		owner := succs[0]
+		if owner.Equal(&vn.Vnode) {
+			// Routing has not caught up with our new predecessor yet
+			err = mergeErrors(err, fmt.Errorf("Key %x routed back to %s", item.Hash, vn.String()))
+			continue
+		}
+		owners[owner.ident()] = owner
+		batches[owner.ident()] = append(batches[owner.ident()], item)
+	}
+
+	// Send each batch, only removing items once acknowledged
+	for key, items := range batches {
+		err = mergeErrors(err, vn.sendItems(owners[key], items, func(item *Item) bool {
+			return vn.isReplica(item.Hash)
+		}))
+	}
+	return err
+}
+
+// Collects up to limit items from our store in (start, end].
+// A limit <= 0 collects everything.
+func (vn *LocalVnode) collectRange(start, end []byte, limit int) []*Item {
+	var items []*Item
+	vn.Store.Range(start, end, func(item *Item) bool {
+		items = append(items, item)
+		return limit <= 0 || len(items) < limit
+	})
+	return items
+}
+
+// RPC: Merges items handed over by another vnode. Storing
+// the same items again has no further effect.
+func (vn *LocalVnode) Transfer(items []*Item) error {
+	if vn.left.Load() {
+		return errVnodeLeft
+	}
+	var err error
+	foreign := false
+	pred := vn.predecessor()
+	for _, item := range items {
+		err = mergeErrors(err, vn.merge(item, false))
+		if pred != nil && !betweenRightIncl(pred.Id, vn.Id, item.Hash) {
+			foreign = true
+		}
+	}
+
+	// Part of the range already moved on to a newer predecessor
+	if foreign {
+		vn.lock.Lock()
+		vn.queueHandoff(nil, pred)
+		vn.lock.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,341 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJoinHandsOffKeys(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create the initial ring and fill it, without replicas
+	conf := kvConf("test")
+	conf.ReplicationFactor = 1
+	r, err := Create(conf, ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Join a second ring, which takes over some of the keys
+	conf2 := kvConf("test2")
+	conf2.ReplicationFactor = 1
+	r2, err := Join(conf2, ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+
+	// Wait for some stabilization
+	<-time.After(200 * time.Millisecond)
+
+	// Every key must be readable through either ring
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		val, err := r2.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+
+	// No vnode keeps keys outside of its range
+	for _, ring := range []*Ring{r, r2} {
+		for _, vn := range ring.Vnodes {
+			vn.Store.Range(vn.Id, vn.Id, func(item *Item) bool {
+				if !betweenRightIncl(vn.predecessor().Id, vn.Id, item.Hash) {
+					t.Fatalf("vnode %s holds foreign key %s", vn.String(), item.Key)
+				}
+				return true
+			})
+		}
+	}
+
+	r.Shutdown()
+	r2.Shutdown()
+}
+
+func TestHandoffDeadTarget(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	vn := r.Vnodes[0]
+	vn.Put(&Item{Key: []byte("foo"), Value: []byte("bar")})
+	hash := vn.Ring.hashKey([]byte("foo"))
+
+	// Hand the key to a vnode that cannot be reached
+	dead := &Vnode{Id: hash, Host: "dead"}
+	vn.queueHandoff(&Vnode{Id: vn.Id}, dead)
+	if err := vn.transferHandoffs(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(vn.handoffs) != 0 {
+		t.Fatalf("expected dead handoff to be dropped")
+	}
+	if _, err := vn.Get([]byte("foo")); err != nil {
+		t.Fatalf("expected key to be kept. %s", err)
+	}
+}
+
+func TestRingLeaveDrainsKeys(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for some stabilization
+	<-time.After(100 * time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Node 1 should leave, taking nothing with it
+	if err := r.Leave(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	ml.Deregister("test")
+	for _, vn := range r.Vnodes {
+		if len(vn.collectRange(vn.Id, vn.Id, 0)) != 0 {
+			t.Fatalf("vnode %s kept keys after leaving", vn.String())
+		}
+	}
+
+	// Wait for the departed vnodes to drop out of the routing state
+	<-time.After(300 * time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if _, err := r2.Get(key); err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+	}
+}
+
+func TestRingLeaveNoSuccessor(t *testing.T) {
+	conf := fastConf()
+	conf.LeaveTimeout = 50 * time.Millisecond
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Put([]byte("foo"), []byte("bar"))
+
+	// There is no other host to hand the key to
+	err = r.Leave()
+	herr, ok := err.(*HandoffError)
+	if !ok {
+		t.Fatalf("expected handoff error, got %v", err)
+	}
+	if len(herr.Keys) != 1 || string(herr.Keys[0]) != "foo" {
+		t.Fatalf("bad keys: %v", herr.Keys)
+	}
+}
+
+// Transport running a hook before each transfer
+type hookTrans struct {
+	Transport
+	lock sync.Mutex
+	hook func()
+}
+
+// Sets the hook to run before each transfer
+func (h *hookTrans) setHook(hook func()) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hook = hook
+}
+
+func (h *hookTrans) Transfer(v *Vnode, items []*Item) error {
+	h.lock.Lock()
+	hook := h.hook
+	h.lock.Unlock()
+	if hook != nil {
+		hook()
+	}
+	return h.Transport.Transfer(v, items)
+}
+
+func TestSendItemsKeepsNewerWrites(t *testing.T) {
+	// Hook the transfers to the other ring
+	ml := InitMLTransport()
+	ht := &hookTrans{Transport: ml}
+	r, err := Create(kvConf("test"), ht)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	vn := r.Vnodes[0]
+	target := r2.Vnodes[0]
+	vn.Put(&Item{Key: []byte("foo"), Value: []byte("bar")})
+	vn.Put(&Item{Key: []byte("baz"), Value: []byte("bar")})
+
+	// Overwrite one of the keys while it is being sent
+	ht.setHook(func() {
+		vn.Put(&Item{Key: []byte("foo"), Value: []byte("new"), Clock: VectorClock{"test": 1}})
+	})
+	items := []*Item{}
+	for _, key := range []string{"foo", "baz"} {
+		item, _ := vn.Get([]byte(key))
+		items = append(items, item)
+	}
+	if err := vn.sendItems(&target.Vnode, items, func(*Item) bool {
+		return false
+	}); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	item, err := vn.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("expected the newer write to be kept. %s", err)
+	}
+	if string(item.Value) != "new" {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+	if _, err := vn.Get([]byte("baz")); err != ErrKeyNotFound {
+		t.Fatalf("expected the sent key to be removed. %v", err)
+	}
+}
+
+func TestLeaveRejectsWritesWhileDraining(t *testing.T) {
+	// Hook the transfers to the other ring
+	ml := InitMLTransport()
+	ht := &hookTrans{Transport: ml}
+	r, err := Create(kvConf("test"), ht)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Stop stabilizing, so only the drain makes transfers
+	r.stopVnodes()
+
+	vn := r.Vnodes[0]
+	vn.Put(&Item{Key: []byte("foo"), Value: []byte("bar")})
+
+	// Try writing while the keys are drained
+	var putErr error
+	ht.setHook(func() {
+		putErr = vn.Put(&Item{Key: []byte("baz"), Value: []byte("bar")})
+	})
+	if err := vn.leave(&r2.Vnodes[0].Vnode, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	ht.setHook(nil)
+	if putErr != errVnodeLeft {
+		t.Fatalf("expected the write to be rejected, got %v", putErr)
+	}
+	if len(vn.collectRange(vn.Id, vn.Id, 0)) != 0 {
+		t.Fatalf("vnode kept keys after leaving")
+	}
+}
+
+func TestTransferRangeKeepsOnlyReplicas(t *testing.T) {
+	// Create three rings, keeping two copies of each key
+	ml := InitMLTransport()
+	var rings []*Ring
+	for i, host := range []string{"test", "test2", "test3"} {
+		conf := kvConf(host)
+		conf.ReplicationFactor = 2
+		var r *Ring
+		var err error
+		if i == 0 {
+			r, err = Create(conf, ml)
+		} else {
+			r, err = Join(conf, ml, "test")
+		}
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		defer r.Shutdown()
+		rings = append(rings, r)
+	}
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Find keys owned by another host that we do and do not replicate
+	r := rings[0]
+	var kept, sent []byte
+	var keeper *LocalVnode
+	for i := 0; i < 1000 && (kept == nil || sent == nil); i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		replicas, err := r.replicas(key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if replicas[0].Host == "test" {
+			continue
+		}
+		if replicas[1].Host == "test" {
+			if kept == nil {
+				kept, keeper = key, ringVnode(replicas[1], r)
+			}
+		} else if sent == nil {
+			sent = key
+		}
+	}
+	if kept == nil || sent == nil {
+		t.Fatalf("no suitable keys found")
+	}
+
+	// Hand each key to its owner as if it had just joined
+	for _, key := range [][]byte{kept, sent} {
+		keeper.Put(&Item{Key: key, Value: key})
+		replicas, _ := r.replicas(key)
+		hash := r.hashKey(key)
+		start := append([]byte(nil), hash...)
+		for i := len(start) - 1; i >= 0; i-- {
+			start[i]--
+			if start[i] != 0xff {
+				break
+			}
+		}
+		if err := keeper.transferRange(start, hash, replicas[0]); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Only the key we still replicate stays behind
+	if _, err := keeper.Get(kept); err != nil {
+		t.Fatalf("expected replicated key to be kept. %s", err)
+	}
+	if _, err := keeper.Get(sent); err != ErrKeyNotFound {
+		t.Fatalf("expected foreign key to be removed. %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package chord
+
+import (
+	"time"
+)
+
+// Default number of consecutive failed stabilizations
+// before a vnode is unhealthy
+const defaultUnhealthyAfter = 3
+
+// VnodeHealth reports how stabilization is going on a local vnode
+type VnodeHealth struct {
+	Vnode      *Vnode
+	Stabilized time.Time // Time of the last stabilization
+	LastError  error     // Error of the last failed stabilization, if any
+	Failures   int       // Consecutive failed stabilizations
+	Healthy    bool      // Whether Failures is below Config.UnhealthyAfter
+}
+
+// Returns the number of failed stabilizations making a vnode unhealthy
+func unhealthyAfter(conf *Config) int {
+	if conf.UnhealthyAfter <= 0 {
+		return defaultUnhealthyAfter
+	}
+	return conf.UnhealthyAfter
+}
+
+/*
+Health returns the stabilization state of each local vnode. The
+failures are also reported through Config.Logger as they happen: each
+failed step is logged with Warn, and a vnode becoming unhealthy with
+Error. They are discarded if no Logger is configured.
+*/
+func (r *Ring) Health() []*VnodeHealth {
+	limit := unhealthyAfter(r.config)
+	health := make([]*VnodeHealth, len(r.Vnodes))
+	for i, vn := range r.Vnodes {
+		vn.lock.RLock()
+		health[i] = &VnodeHealth{
+			Vnode:      &vn.Vnode,
+			Stabilized: vn.Stabilized,
+			LastError:  vn.lastError,
+			Failures:   vn.failures,
+			Healthy:    vn.failures < limit,
+		}
+		vn.lock.RUnlock()
+	}
+	return health
+}
+
+// Healthy checks that none of the local vnodes has failed
+// to stabilize Config.UnhealthyAfter times in a row
+func (r *Ring) Healthy() bool {
+	for _, h := range r.Health() {
+		if !h.Healthy {
+			return false
+		}
+	}
+	return true
+}
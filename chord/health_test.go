@@ -0,0 +1,73 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the health of a local vnode
+func vnodeHealth(r *Ring, vn *LocalVnode) *VnodeHealth {
+	for _, h := range r.Health() {
+		if h.Vnode.Equal(&vn.Vnode) {
+			return h
+		}
+	}
+	return nil
+}
+
+func TestRingHealth(t *testing.T) {
+	logger := &testLogger{}
+	conf := fastConf()
+	conf.Logger = logger
+	conf.UnhealthyAfter = 2
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	<-time.After(100 * time.Millisecond)
+	if !r.Healthy() {
+		t.Fatalf("expected a healthy ring, got %+v", r.Health())
+	}
+
+	// Point a vnode at a successor that cannot be reached
+	vn := r.Vnodes[0]
+	dead := &Vnode{Id: []byte{1}, Host: "dead"}
+	vn.lock.Lock()
+	succs := append([]*Vnode(nil), vn.Successors...)
+	for i := range vn.Successors {
+		vn.Successors[i] = nil
+	}
+	vn.Successors[0] = dead
+	vn.lock.Unlock()
+
+	<-time.After(200 * time.Millisecond)
+	h := vnodeHealth(r, vn)
+	if h.Healthy || h.Failures < 2 || h.LastError == nil {
+		t.Fatalf("expected an unhealthy vnode, got %+v", h)
+	}
+	if r.Healthy() {
+		t.Fatalf("expected an unhealthy ring")
+	}
+	if !logger.logged("WARN Error checking for new successor") {
+		t.Fatalf("expected the error to be logged")
+	}
+	if !logger.logged("ERROR Vnode failed to stabilize repeatedly") {
+		t.Fatalf("expected the vnode to be reported unhealthy")
+	}
+
+	// The vnode recovers along with its successors
+	vn.lock.Lock()
+	copy(vn.Successors, succs)
+	vn.lock.Unlock()
+
+	<-time.After(200 * time.Millisecond)
+	h = vnodeHealth(r, vn)
+	if !h.Healthy || h.Failures != 0 || h.LastError == nil {
+		t.Fatalf("expected a recovered vnode, got %+v", h)
+	}
+	if !r.Healthy() {
+		t.Fatalf("expected a healthy ring")
+	}
+}
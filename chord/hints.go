@@ -0,0 +1,225 @@
+package chord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// Directory under Config.DataDir holding the hints
+	hintsDir = "hints"
+
+	// Number of hints kept, if not configured
+	defaultMaxHints = 10000
+)
+
+// A write held for a replica that could not be reached
+type hint struct {
+	Target *Vnode
+	Item   *Item
+}
+
+/*
+Stores hints for writes to replicas that failed to respond to a ping,
+so they can be delivered once the replica is reachable again. Hints are
+kept in a Store, which is durable if Config.DataDir is set, and bounded
+by Config.MaxHints. Hints for the same key and replica are merged.
+*/
+type hintStore struct {
+	ring  *Ring
+	store Store
+	max   int
+
+	lock         sync.Mutex
+	count        int
+	lastDelivery time.Time
+	delivering   bool // Whether hints are being delivered
+}
+
+// Opens the hint store of a ring, reloading any durable hints
+func openHints(r *Ring) (*hintStore, error) {
+	conf := r.config
+	h := &hintStore{ring: r, max: conf.MaxHints}
+	if h.max <= 0 {
+		h.max = defaultMaxHints
+	}
+	if conf.DataDir != "" {
+		store, err := OpenDiskStore(filepath.Join(conf.DataDir, hintsDir), conf)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open hints! Got %s", err)
+		}
+		h.store = store
+	} else {
+		h.store = NewMemoryStore()
+	}
+	h.store.Range(nil, nil, func(*Item) bool {
+		h.count++
+		return true
+	})
+	return h, nil
+}
+
+// Returns the key a hint is stored under
+func hintKey(target *Vnode, key []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(target.ident())
+	buf.WriteByte('/')
+	buf.Write(key)
+	return buf.Bytes()
+}
+
+// Holds a write for a replica. Fails if the store is full.
+func (h *hintStore) add(target *Vnode, item *Item) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// Merge with a hint held for the same key
+	key := hintKey(target, item.Key)
+	stored, err := h.store.Get(key)
+	if err == nil {
+		old, decErr := decodeHint(stored.Value)
+		if decErr != nil {
+			return decErr
+		}
+		item = mergeItems(old.Item, item)
+	} else if err != ErrKeyNotFound {
+		return err
+	} else if h.count >= h.max {
+		h.ring.stats.hintsDropped.Add(1)
+		return fmt.Errorf("Hint store is full")
+	}
+
+	value, err := encodeHint(&hint{Target: target, Item: item})
+	if err != nil {
+		return err
+	}
+	if err := h.store.Put(&Item{Key: key, Hash: h.ring.hashKey(key), Value: value}); err != nil {
+		return err
+	}
+	if stored == nil {
+		h.count++
+	}
+	h.ring.stats.hintsStored.Add(1)
+	return nil
+}
+
+// Returns the number of hints held
+func (h *hintStore) pending() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+// Delivers the hints held for replicas that are reachable again. Runs
+// at most once per Config.StabilizeMin, since every vnode triggers it.
+// The lock is not held while contacting the replicas.
+func (h *hintStore) deliver() {
+	targets := h.collect()
+
+	// Deliver to each replica that responds
+	trans := h.ring.transport
+	var delivered []*heldHint
+	for _, hints := range targets {
+		if alive, _ := trans.Ping(hints[0].hint.Target); !alive {
+			continue
+		}
+		for _, hd := range hints {
+			if err := trans.Put(hd.hint.Target, hd.hint.Item); err != nil {
+				break
+			}
+			delivered = append(delivered, hd)
+			h.ring.stats.hintsDelivered.Add(1)
+		}
+	}
+	h.remove(delivered)
+}
+
+// A hint read from the store, along with its stored key and value
+type heldHint struct {
+	key   []byte
+	value []byte
+	hint  *hint
+}
+
+// Groups the hints to deliver by replica, dropping corrupt ones.
+// Returns nothing if a delivery is not due.
+func (h *hintStore) collect() map[string][]*heldHint {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.count == 0 || h.delivering || time.Since(h.lastDelivery) < h.ring.config.StabilizeMin {
+		return nil
+	}
+	h.lastDelivery = time.Now()
+	h.delivering = true
+
+	targets := make(map[string][]*heldHint)
+	var corrupt [][]byte
+	h.store.Range(nil, nil, func(item *Item) bool {
+		hnt, err := decodeHint(item.Value)
+		if err != nil {
+			h.ring.logger().Error("Dropping corrupt hint", "error", err)
+			corrupt = append(corrupt, item.Key)
+			return true
+		}
+		target := hnt.Target.ident()
+		targets[target] = append(targets[target], &heldHint{item.Key, item.Value, hnt})
+		return true
+	})
+	for _, key := range corrupt {
+		h.store.Delete(key)
+		h.count--
+	}
+	return targets
+}
+
+// Removes the hints that were delivered, keeping those
+// merged with a newer write since they were collected
+func (h *hintStore) remove(delivered []*heldHint) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.delivering = false
+	for _, hd := range delivered {
+		stored, err := h.store.Get(hd.key)
+		if err != nil || !bytes.Equal(stored.Value, hd.value) {
+			continue
+		}
+		h.store.Delete(hd.key)
+		h.count--
+	}
+}
+
+// Closes the underlying store
+func (h *hintStore) close() error {
+	return h.store.Close()
+}
+
+func encodeHint(hnt *hint) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(hnt); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeHint(buf []byte) (*hint, error) {
+	hnt := &hint{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(hnt); err != nil {
+		return nil, err
+	}
+	return hnt, nil
+}
+
+// Holds a write for a replica that failed it, if it does not respond
+// to a ping either
+func (r *Ring) hintIfDown(target *Vnode, item *Item) {
+	if alive, _ := r.transport.Ping(target); alive {
+		return
+	}
+	if err := r.hints.add(target, item); err != nil {
+		r.logger().Error("Failed to store hint", target.logFields("error", err)...)
+	}
+}
@@ -0,0 +1,184 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRingHintsDelivered(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Write while the second host is down
+	ft.fail("test2")
+	if err := r.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// The quorum is met before the write to the second host fails
+	<-time.After(50 * time.Millisecond)
+	stats := r.Stats()
+	if stats.HintsStored != 1 || stats.PendingHints != 1 {
+		t.Fatalf("expected a hint, got %+v", stats)
+	}
+
+	// The hint is delivered once the host is back
+	ft.fail("")
+	<-time.After(200 * time.Millisecond)
+	stats = r.Stats()
+	if stats.HintsDelivered != 1 || stats.PendingHints != 0 {
+		t.Fatalf("expected hint delivery, got %+v", stats)
+	}
+	found := false
+	for _, vn := range r2.Vnodes {
+		item, err := vn.Store.Get([]byte("foo"))
+		if err == nil {
+			found = true
+			if !bytes.Equal(item.Value, []byte("bar")) {
+				t.Fatalf("bad value: %s", item.Value)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected the second host to hold the key")
+	}
+}
+
+func TestRingHintsBounded(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+
+	// Create two rings, holding a single hint
+	conf := kvConf("test")
+	conf.MaxHints = 1
+	r, err := Create(conf, ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	ft.fail("test2")
+	for i := 0; i < 2; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	<-time.After(50 * time.Millisecond)
+	stats := r.Stats()
+	if stats.HintsDropped != 1 || stats.PendingHints != 1 {
+		t.Fatalf("expected a dropped hint, got %+v", stats)
+	}
+}
+
+func TestHintsDurable(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	conf := fastConf()
+	conf.DataDir = dir
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Hold a write for a vnode that is never reachable
+	target := &Vnode{Id: []byte{1}, Host: "dead"}
+	item := &Item{Key: []byte("foo"), Value: []byte("bar"), Clock: VectorClock{"test": 1}}
+	if err := r.hints.add(target, item); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Shutdown()
+
+	// Recreate the ring from the same directory
+	r, err = Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	if n := r.Stats().PendingHints; n != 1 {
+		t.Fatalf("expected the hint to be kept, got %d", n)
+	}
+}
+
+// Transport accepting every write, running a hook on each
+type putHookTrans struct {
+	Transport
+	hook func(*Item)
+}
+
+func (p *putHookTrans) Ping(*Vnode) (bool, error) {
+	return true, nil
+}
+
+func (p *putHookTrans) Put(v *Vnode, item *Item) error {
+	p.hook(item)
+	return nil
+}
+
+func TestHintsKeptIfMergedDuringDelivery(t *testing.T) {
+	target := &Vnode{Id: []byte{1}, Host: "remote"}
+	newer := &Item{Key: []byte("foo"), Value: []byte("baz"), Clock: VectorClock{"test": 2}}
+
+	// A newer write is held while the hint is delivered
+	r := &Ring{}
+	var h *hintStore
+	var puts int
+	err := r.init(fastConf(), &putHookTrans{Transport: &BlackholeTransport{}, hook: func(item *Item) {
+		puts++
+		if puts == 1 {
+			if err := h.add(target, newer); err != nil {
+				t.Fatalf("unexpected err. %s", err)
+			}
+		}
+	}})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.closeStores()
+	h = r.hints
+
+	item := &Item{Key: []byte("foo"), Value: []byte("bar"), Clock: VectorClock{"test": 1}}
+	if err := h.add(target, item); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	h.deliver()
+	if n := h.pending(); n != 1 {
+		t.Fatalf("expected the newer hint to be kept, got %d", n)
+	}
+
+	// The newer write goes out on the next delivery
+	h.lastDelivery = time.Time{}
+	h.deliver()
+	if n := h.pending(); n != 0 || puts != 2 {
+		t.Fatalf("expected the newer hint to be delivered, got %d pending, %d puts", n, puts)
+	}
+}
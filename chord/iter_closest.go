@@ -7,6 +7,8 @@ import (
 type closestPreceedingVnodeIterator struct {
 	key           []byte
 	vn            *LocalVnode
+	successors    []*Vnode // Copies of the routing state of vn
+	fingers       []*Vnode
 	finger_idx    int
 	successor_idx int
 	yielded       map[string]struct{}
@@ -15,8 +17,12 @@ type closestPreceedingVnodeIterator struct {
 func (cp *closestPreceedingVnodeIterator) init(vn *LocalVnode, key []byte) {
 	cp.key = key
 	cp.vn = vn
-	cp.successor_idx = len(vn.Successors) - 1
-	cp.finger_idx = len(vn.Finger) - 1
+	vn.lock.RLock()
+	cp.successors = append([]*Vnode(nil), vn.Successors...)
+	cp.fingers = append([]*Vnode(nil), vn.Finger...)
+	vn.lock.RUnlock()
+	cp.successor_idx = len(cp.successors) - 1
+	cp.finger_idx = len(cp.fingers) - 1
 	cp.yielded = make(map[string]struct{})
 }
 
@@ -29,14 +35,14 @@ func (cp *closestPreceedingVnodeIterator) Next() *Vnode {
 	vn := cp.vn
 	var i int
 	for i = cp.successor_idx; i >= 0; i-- {
-		if vn.Successors[i] == nil {
+		if cp.successors[i] == nil {
 			continue
 		}
-		if _, ok := cp.yielded[vn.Successors[i].String()]; ok {
+		if _, ok := cp.yielded[cp.successors[i].ident()]; ok {
 			continue
 		}
-		if between(vn.Id, cp.key, vn.Successors[i].Id) {
-			successor_node = vn.Successors[i]
+		if between(vn.Id, cp.key, cp.successors[i].Id) {
+			successor_node = cp.successors[i]
 			break
 		}
 	}
@@ -44,14 +50,14 @@ func (cp *closestPreceedingVnodeIterator) Next() *Vnode {
 
 	// Scan to find the next finger
 	for i = cp.finger_idx; i >= 0; i-- {
-		if vn.Finger[i] == nil {
+		if cp.fingers[i] == nil {
 			continue
 		}
-		if _, ok := cp.yielded[vn.Finger[i].String()]; ok {
+		if _, ok := cp.yielded[cp.fingers[i].ident()]; ok {
 			continue
 		}
-		if between(vn.Id, cp.key, vn.Finger[i].Id) {
-			finger_node = vn.Finger[i]
+		if between(vn.Id, cp.key, cp.fingers[i].Id) {
+			finger_node = cp.fingers[i]
 			break
 		}
 	}
@@ -68,17 +74,17 @@ func (cp *closestPreceedingVnodeIterator) Next() *Vnode {
 		} else {
 			cp.finger_idx--
 		}
-		cp.yielded[closest.String()] = struct{}{}
+		cp.yielded[closest.ident()] = struct{}{}
 		return closest
 
 	} else if successor_node != nil {
 		cp.successor_idx--
-		cp.yielded[successor_node.String()] = struct{}{}
+		cp.yielded[successor_node.ident()] = struct{}{}
 		return successor_node
 
 	} else if finger_node != nil {
 		cp.finger_idx--
-		cp.yielded[finger_node.String()] = struct{}{}
+		cp.yielded[finger_node.ident()] = struct{}{}
 		return finger_node
 	}
 
@@ -16,12 +16,12 @@ func TestNextClosest(t *testing.T) {
 	v7 := &Vnode{Id: []byte{62}}
 
 	// Make a vnode
-	vn := &localVnode{}
+	vn := &LocalVnode{}
 	vn.Id = []byte{54}
-	vn.successors = []*Vnode{v6, v7, nil}
-	vn.finger = []*Vnode{v6, v6, v7, v1, v2, v4, nil}
-	vn.ring = &Ring{}
-	vn.ring.config = &Config{hashBits: 6}
+	vn.Successors = []*Vnode{v6, v7, nil}
+	vn.Finger = []*Vnode{v6, v6, v7, v1, v2, v4, nil}
+	vn.Ring = &Ring{}
+	vn.Ring.config = &Config{HashBits: 6}
 
 	// Make an iterator
 	k := []byte{32}
@@ -66,12 +66,12 @@ func TestNextClosestNoSucc(t *testing.T) {
 	v7 := &Vnode{Id: []byte{62}}
 
 	// Make a vnode
-	vn := &localVnode{}
+	vn := &LocalVnode{}
 	vn.Id = []byte{54}
-	vn.successors = []*Vnode{nil}
-	vn.finger = []*Vnode{v6, v6, v7, v1, v2, v4, nil}
-	vn.ring = &Ring{}
-	vn.ring.config = &Config{hashBits: 6}
+	vn.Successors = []*Vnode{nil}
+	vn.Finger = []*Vnode{v6, v6, v7, v1, v2, v4, nil}
+	vn.Ring = &Ring{}
+	vn.Ring.config = &Config{HashBits: 6}
 
 	// Make an iterator
 	k := []byte{32}
@@ -116,12 +116,12 @@ func TestNextClosestNoFinger(t *testing.T) {
 	v7 := &Vnode{Id: []byte{62}}
 
 	// Make a vnode
-	vn := &localVnode{}
+	vn := &LocalVnode{}
 	vn.Id = []byte{54}
-	vn.successors = []*Vnode{v6, v7, v7, nil}
-	vn.finger = []*Vnode{nil, nil, nil}
-	vn.ring = &Ring{}
-	vn.ring.config = &Config{hashBits: 6}
+	vn.Successors = []*Vnode{v6, v7, v7, nil}
+	vn.Finger = []*Vnode{nil, nil, nil}
+	vn.Ring = &Ring{}
+	vn.Ring.config = &Config{HashBits: 6}
 
 	// Make an iterator
 	k := []byte{32}
@@ -0,0 +1,478 @@
+package chord
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned when the vnode owning a key has no value for it
+var ErrKeyNotFound = errors.New("Key not found")
+
+// UnreachableError is returned when the vnode owning a key could
+// not be contacted to carry out an operation
+type UnreachableError struct {
+	Vnode *Vnode // Vnode that was contacted, nil if the lookup failed
+	Err   error  // Underlying transport error
+}
+
+func (e *UnreachableError) Error() string {
+	if e.Vnode == nil {
+		return fmt.Sprintf("Failed to find owner of key! Got %s", e.Err)
+	}
+	return fmt.Sprintf("Failed to contact %s@%s! Got %s", e.Vnode.String(), e.Vnode.Host, e.Err)
+}
+
+// QuorumError is returned when fewer replicas than the read or write
+// quorum of an operation acknowledged it
+type QuorumError struct {
+	Required int   // Number of acknowledgements needed
+	Acks     int   // Number of acknowledgements received
+	Err      error // Errors from the replicas that failed
+}
+
+func (e *QuorumError) Error() string {
+	return fmt.Sprintf("Failed to reach quorum of %d replicas, got %d! Got %s", e.Required, e.Acks, e.Err)
+}
+
+// Option adjusts a single key/value operation
+type Option func(*options)
+
+// Settings of a key/value operation
+type options struct {
+	readQuorum  int
+	writeQuorum int
+	context     []byte
+	ttl         time.Duration
+}
+
+// WithReadQuorum sets the number of replicas that must answer a Get,
+// overriding Config.ReadQuorum
+func WithReadQuorum(n int) Option {
+	return func(o *options) {
+		o.readQuorum = n
+	}
+}
+
+// WithWriteQuorum sets the number of replicas that must acknowledge
+// a Put or Delete, overriding Config.WriteQuorum
+func WithWriteQuorum(n int) Option {
+	return func(o *options) {
+		o.writeQuorum = n
+	}
+}
+
+// WithContext passes the context token returned by GetVersions to a
+// Put, so that the new value supersedes every version that was read
+func WithContext(ctx []byte) Option {
+	return func(o *options) {
+		o.context = ctx
+	}
+}
+
+// WithTTL makes the value written by a Put expire after a duration.
+// An expired value reads as not found, and is swept like a delete.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// Applies the options of an operation on top of the config defaults
+func (r *Ring) options(opts []Option) *options {
+	o := &options{
+		readQuorum:  r.config.ReadQuorum,
+		writeQuorum: r.config.WriteQuorum,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.readQuorum < 1 {
+		o.readQuorum = 1
+	}
+	if o.writeQuorum < 1 {
+		o.writeQuorum = 1
+	}
+	return o
+}
+
+// Put stores a value under the given key on the vnode that owns it,
+// and on the next Config.ReplicationFactor-1 successors that are on
+// distinct hosts. Returns once the write quorum has acknowledged it.
+//
+// The value supersedes the versions covered by the context token
+// given using WithContext. Without one, it supersedes the earlier
+// writes made through this ring since it started, but is concurrent
+// with values written through other hosts, or before a restart, that
+// it has not seen.
+//
+// Returns ErrKeyLocked, without writing the value, if the key is
+// being written by a transaction.
+func (r *Ring) Put(key, value []byte, opts ...Option) error {
+	o := r.options(opts)
+	clock, err := decodeContext(o.context)
+	if err != nil {
+		return err
+	}
+	r.tick(clock)
+	item := &Item{Key: key, Value: value, Clock: clock}
+	if o.ttl > 0 {
+		item.Expires = time.Now().Add(o.ttl)
+	}
+	return r.writeNew(item, o)
+}
+
+// Writes an item to the replicas of its key, holding a hint
+// for each replica that is down
+func (r *Ring) write(item *Item, o *options) error {
+	replicas, err := r.replicas(item.Key)
+	if err != nil {
+		return err
+	}
+	return r.writeReplicas(item, replicas, nil, o)
+}
+
+// Writes a new value to the replicas of its key. The owner refuses
+// keys locked by a transaction, so it is written first, and nothing
+// is written if it refuses.
+func (r *Ring) writeNew(item *Item, o *options) error {
+	replicas, err := r.replicas(item.Key)
+	if err != nil {
+		return err
+	}
+	ownerErr := r.transport.Put(replicas[0], item)
+	if ownerErr == ErrKeyLocked {
+		return ownerErr
+	}
+	return r.writeReplicas(item, replicas, &ownerErr, o)
+}
+
+// Writes an item to replicas, holding a hint for each replica that
+// is down. If owned is set, the owner was already written, and it
+// holds the result.
+func (r *Ring) writeReplicas(item *Item, replicas []*Vnode, owned *error, o *options) error {
+	_, err := r.quorum(replicas, o.writeQuorum, func(idx int, vn *Vnode) error {
+		var err error
+		if idx == 0 && owned != nil {
+			err = *owned
+		} else {
+			err = r.transport.Put(vn, item)
+		}
+		if err != nil {
+			r.hintIfDown(vn, item)
+		}
+		return err
+	})
+	return err
+}
+
+// Get returns the value stored under a key, or ErrKeyNotFound. The
+// owner of the key is asked first, so a read sees the writes that
+// returned before it, then the other replicas until the read quorum
+// has answered. If the owner does not answer, the other replicas are
+// asked instead. If concurrent writes left several values, a
+// *ConflictError holding all of them is returned.
+func (r *Ring) Get(key []byte, opts ...Option) ([]byte, error) {
+	values, ctx, err := r.GetVersions(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) > 1 {
+		return nil, &ConflictError{Key: key, Values: values, Context: ctx}
+	}
+	return values[0], nil
+}
+
+// GetVersions returns the values stored under a key, along with a
+// context token to pass to Put using WithContext. There is more than
+// one value only if concurrent writes conflict.
+func (r *Ring) GetVersions(key []byte, opts ...Option) ([][]byte, []byte, error) {
+	item, err := r.read(key, r.options(opts))
+	if err != nil {
+		return nil, nil, err
+	}
+	vs := item.versions()
+	live := liveVersions(vs, time.Now())
+	if len(live) == 0 {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	// The context also covers tombstones, so the next write supersedes them
+	ctx, err := encodeContext(vs)
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make([][]byte, len(live))
+	for idx, v := range live {
+		values[idx] = v.Value
+	}
+	return values, ctx, nil
+}
+
+// Reads a key from its owner, which every write reaches first, then
+// from the other replicas until the read quorum has answered, merging
+// the versions they returned. If the owner does not answer a ping, the
+// other replicas are asked instead. Once every replica has answered,
+// the ones that returned stale or missing data are repaired.
+func (r *Ring) read(key []byte, o *options) (*Item, error) {
+	replicas, err := r.replicas(key)
+	if err != nil {
+		return nil, err
+	}
+	if o.readQuorum > len(replicas) {
+		return nil, &QuorumError{Required: o.readQuorum,
+			Err: fmt.Errorf("Only %d replicas available", len(replicas))}
+	}
+	items := make([]*Item, len(replicas))
+	answered := make([]bool, len(replicas))
+	get := func(idx int, vn *Vnode) error {
+		item, err := r.transport.Get(vn, key)
+		if err == ErrKeyNotFound {
+			err = nil
+		}
+		items[idx], answered[idx] = item, err == nil
+		return err
+	}
+
+	// Ask the owner first
+	need := o.readQuorum
+	alive, ownerErr := r.transport.Ping(replicas[0])
+	if alive {
+		ownerErr = get(0, replicas[0])
+	} else if ownerErr == nil {
+		ownerErr = fmt.Errorf("Vnode is not alive")
+	}
+	if ownerErr == nil {
+		need--
+	}
+
+	// Ask the other replicas, waiting for as many as the owner did not cover
+	if need > len(replicas)-1 {
+		return nil, &QuorumError{Required: o.readQuorum, Err: mergeErrors(r.keyError(replicas[0], ownerErr),
+			fmt.Errorf("Only %d other replicas available", len(replicas)-1))}
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(replicas) - 1)
+	acked, err := r.quorum(replicas[1:], need, func(idx int, vn *Vnode) error {
+		defer wg.Done()
+		return get(idx+1, vn)
+	})
+	go func() {
+		wg.Wait()
+		r.readRepair(replicas, items, answered)
+	}()
+	if qerr, ok := err.(*QuorumError); ok {
+		qerr.Required = o.readQuorum
+		if ownerErr == nil {
+			qerr.Acks++
+		} else {
+			qerr.Err = mergeErrors(r.keyError(replicas[0], ownerErr), qerr.Err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge in replica order, preferring the owner's value
+	for idx := range acked {
+		acked[idx]++
+	}
+	if ownerErr == nil {
+		acked = append(acked, 0)
+	}
+	sort.Ints(acked)
+	merged := mergeAll(items, acked)
+	if merged == nil {
+		return nil, ErrKeyNotFound
+	}
+	return merged, nil
+}
+
+// Pushes the newest versions of an item to the replicas that
+// answered a read without them
+func (r *Ring) readRepair(replicas []*Vnode, items []*Item, answered []bool) {
+	var idxs []int
+	for idx := range replicas {
+		if answered[idx] {
+			idxs = append(idxs, idx)
+		}
+	}
+	merged := mergeAll(items, idxs)
+	if merged == nil {
+		return
+	}
+	for _, idx := range idxs {
+		if !isStale(items[idx], merged) {
+			continue
+		}
+		r.stats.readRepairs.Add(1)
+		if err := r.transport.Put(replicas[idx], merged); err != nil {
+			r.stats.readRepairFailures.Add(1)
+		}
+	}
+}
+
+// Merges the items at the given indexes, skipping missing ones.
+// Returns nil if there are none.
+func mergeAll(items []*Item, idxs []int) *Item {
+	var merged *Item
+	for _, idx := range idxs {
+		if items[idx] == nil {
+			continue
+		} else if merged == nil {
+			merged = items[idx]
+		} else {
+			merged = mergeItems(merged, items[idx])
+		}
+	}
+	return merged
+}
+
+// Checks if a copy of an item lacks any of the merged versions
+func isStale(item, merged *Item) bool {
+	return item == nil || item.Version < merged.Version || lacksVersions(item, merged)
+}
+
+// Checks if an item lacks any of the versions of another
+func lacksVersions(item, other *Item) bool {
+	for _, v := range other.versions() {
+		found := false
+		for _, have := range item.versions() {
+			if have.Clock.Equal(v.Clock) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes a key by writing a tombstone to each of its replicas,
+// returning once the write quorum has acknowledged it. The tombstone
+// supersedes the versions covered by the context token if one is given,
+// otherwise those read from the replicas. It is kept for
+// Config.TombstoneGrace, so older copies cannot come back. Deleting a
+// key that does not exist is not an error.
+func (r *Ring) Delete(key []byte, opts ...Option) error {
+	o := r.options(opts)
+	clock, err := decodeContext(o.context)
+	if err != nil {
+		return err
+	}
+	if o.context == nil {
+		item, err := r.read(key, o)
+		if err == nil {
+			for _, v := range item.versions() {
+				clock.Merge(v.Clock)
+			}
+		} else if err != ErrKeyNotFound {
+			return err
+		}
+	}
+	r.tick(clock)
+	return r.writeNew(&Item{Key: key, Clock: clock, Deleted: time.Now()}, o)
+}
+
+// Hashes a key onto the ring
+func (r *Ring) hashKey(key []byte) []byte {
+	h := r.config.HashFunc()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// Finds the replicas of a key, starting with its owner
+func (r *Ring) replicas(key []byte) ([]*Vnode, error) {
+	replicas, err := r.replicasForHash(r.hashKey(key))
+	if err != nil {
+		return nil, &UnreachableError{Err: err}
+	}
+	return replicas, nil
+}
+
+// Finds the vnode owning a hash, followed by the successors on
+// distinct hosts that hold a replica, up to the replication factor
+func (r *Ring) replicasForHash(hash []byte) ([]*Vnode, error) {
+	succs, err := r.lookupHash(r.config.NumSuccessors, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(succs) == 0 {
+		return nil, fmt.Errorf("No successor for key!")
+	}
+
+	n := replicationFactor(r.config)
+	replicas := []*Vnode{succs[0]}
+	hosts := map[string]bool{succs[0].Host: true}
+	for _, s := range succs[1:] {
+		if len(replicas) == n {
+			break
+		}
+		if s == nil || hosts[s.Host] {
+			continue
+		}
+		replicas = append(replicas, s)
+		hosts[s.Host] = true
+	}
+	return replicas, nil
+}
+
+// Runs an operation against each replica in parallel, returning the
+// indexes of the replicas that succeeded once need of them have, or a
+// QuorumError once too many have failed. If need is zero, it returns
+// at once. Each call is bounded by the transport timeout, and the
+// slower replicas finish in the background.
+func (r *Ring) quorum(replicas []*Vnode, need int, f func(int, *Vnode) error) ([]int, error) {
+	if need > len(replicas) {
+		return nil, &QuorumError{Required: need,
+			Err: fmt.Errorf("Only %d replicas available", len(replicas))}
+	}
+
+	type result struct {
+		idx int
+		err error
+	}
+	resCh := make(chan result, len(replicas))
+	for idx, vn := range replicas {
+		go func(idx int, vn *Vnode) {
+			resCh <- result{idx, f(idx, vn)}
+		}(idx, vn)
+	}
+
+	// Wait until the quorum is reached or can no longer be
+	var acked []int
+	var err error
+	for failed := 0; len(acked) < need; {
+		if failed > len(replicas)-need {
+			return nil, &QuorumError{Required: need, Acks: len(acked), Err: err}
+		}
+		res := <-resCh
+		if res.err != nil {
+			err = mergeErrors(err, r.keyError(replicas[res.idx], res.err))
+			failed++
+			continue
+		}
+		acked = append(acked, res.idx)
+	}
+	return acked, nil
+}
+
+// Returns the configured replication factor
+func replicationFactor(conf *Config) int {
+	if conf.ReplicationFactor < 1 {
+		return 1
+	}
+	return conf.ReplicationFactor
+}
+
+// Converts an error from a key operation into the error returned to the caller
+func (r *Ring) keyError(vn *Vnode, err error) error {
+	if err == nil || err == ErrKeyNotFound {
+		return err
+	}
+	return &UnreachableError{Vnode: vn, Err: err}
+}
@@ -0,0 +1,671 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Config for a small ring, whose vnode IDs are distinct
+func kvConf(host string) *Config {
+	conf := fastConf()
+	conf.Hostname = host
+	conf.NumVnodes = 3
+	return conf
+}
+
+func TestRingPutGetDelete(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create the initial ring
+	conf := fastConf()
+	r, err := Create(conf, ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	if err := r.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	val, err := r.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("bad value: %s", val)
+	}
+
+	if err := r.Delete([]byte("foo")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := r.Get([]byte("foo")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestRingGetAcrossRings(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create the initial ring
+	conf := kvConf("test")
+	r, err := Create(conf, ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Create a second ring
+	conf2 := kvConf("test2")
+	r2, err := Join(conf2, ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Write through one ring to both hosts, read through the other
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key, WithWriteQuorum(2)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		val, err := r2.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+
+	r.Shutdown()
+	r2.Shutdown()
+}
+
+func TestRingGetUnreachable(t *testing.T) {
+	r := &Ring{}
+	err := r.keyError(&Vnode{Id: []byte{1}, Host: "test"}, fmt.Errorf("down"))
+	if _, ok := err.(*UnreachableError); !ok {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+	if r.keyError(nil, ErrKeyNotFound) != ErrKeyNotFound {
+		t.Fatalf("expected not found to pass through")
+	}
+}
+
+func TestTCPPutGet(t *testing.T) {
+	listen := "localhost:10041"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	vn := &r.Vnodes[0].Vnode
+	clock := VectorClock{"test": 1}
+	if err := trans.Put(vn, &Item{Key: []byte("foo"), Value: []byte("bar"), Clock: clock}); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	item, err := trans.Get(vn, []byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("bar")) {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+	if !item.Clock.Equal(clock) {
+		t.Fatalf("bad clock: %v", item.Clock)
+	}
+	items, err := trans.Scan(vn, vn.Id, vn.Id, 0)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(items) != 1 || !bytes.Equal(items[0].Key, []byte("foo")) {
+		t.Fatalf("bad scan: %v", items)
+	}
+	hashes, err := trans.MerkleNodes(vn, vn.Id, vn.Id, []int{0})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(hashes) != 1 || len(hashes[0]) == 0 {
+		t.Fatalf("bad merkle nodes: %v", hashes)
+	}
+	leaf := merkleLeaf(items[0].Hash)
+	digests, err := trans.KeyDigests(vn, vn.Id, vn.Id, []int{leaf})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(digests) != 1 || !bytes.Equal(digests[0].Key, []byte("foo")) {
+		t.Fatalf("bad digests: %v", digests)
+	}
+	if err := trans.Delete(vn, []byte("foo")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	item, err = trans.Get(vn, []byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !item.versions()[0].tombstone() || len(item.Siblings) != 0 || item.Version != 2 {
+		t.Fatalf("expected a tombstone, got %+v", item)
+	}
+
+	// Deletes are refused once the vnode left
+	r.Vnodes[0].left.Store(true)
+	if err := trans.Delete(vn, []byte("foo")); err != errVnodeLeft {
+		t.Fatalf("expected the delete to be refused, got %v", err)
+	}
+}
+
+func TestVnodeScanLimit(t *testing.T) {
+	conf := fastConf()
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	vn := r.Vnodes[0]
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := vn.Put(&Item{Key: key, Value: key}); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Page through the whole ring
+	var seen int
+	start := vn.Id
+	for {
+		items, err := vn.Scan(start, vn.Id, 3)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		seen += len(items)
+		if len(items) < 3 {
+			break
+		}
+		start = items[len(items)-1].Hash
+	}
+	if seen != 10 {
+		t.Fatalf("expected 10 items, got %d", seen)
+	}
+}
+
+func TestRingPutReplicates(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key, WithWriteQuorum(2)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Each key is stored once on every host
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		for _, ring := range []*Ring{r, r2} {
+			var copies int
+			for _, vn := range ring.Vnodes {
+				if _, err := vn.Get(key); err == nil {
+					copies++
+				}
+			}
+			if copies != 1 {
+				t.Fatalf("expected 1 copy of %s on %s, got %d", key, ring.config.Hostname, copies)
+			}
+		}
+	}
+}
+
+// Returns the local vnode of one of the rings matching a vnode
+func ringVnode(v *Vnode, rings ...*Ring) *LocalVnode {
+	for _, r := range rings {
+		for _, vn := range r.Vnodes {
+			if vn.Equal(v) {
+				return vn
+			}
+		}
+	}
+	return nil
+}
+
+// Transport where the vnodes of one host fail pings and key
+// operations, while still taking part in routing
+type failTrans struct {
+	*MultiLocalTrans
+	lock sync.RWMutex
+	host string
+}
+
+// Makes the vnodes of a host fail, or none if empty
+func (p *failTrans) fail(host string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.host = host
+}
+
+// Checks if a vnode fails
+func (p *failTrans) down(v *Vnode) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return v.Host == p.host
+}
+
+func (p *failTrans) Ping(v *Vnode) (bool, error) {
+	if p.down(v) {
+		return false, fmt.Errorf("ping failed")
+	}
+	return p.MultiLocalTrans.Ping(v)
+}
+
+func (p *failTrans) Get(v *Vnode, key []byte) (*Item, error) {
+	if p.down(v) {
+		return nil, fmt.Errorf("get failed")
+	}
+	return p.MultiLocalTrans.Get(v, key)
+}
+
+func (p *failTrans) Put(v *Vnode, item *Item) error {
+	if p.down(v) {
+		return fmt.Errorf("put failed")
+	}
+	return p.MultiLocalTrans.Put(v, item)
+}
+
+func (p *failTrans) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	if p.down(targets[0]) {
+		return nil, fmt.Errorf("get failed")
+	}
+	return p.MultiLocalTrans.MultiGet(targets, keys)
+}
+
+func (p *failTrans) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	if p.down(targets[0]) {
+		return nil, fmt.Errorf("put failed")
+	}
+	return p.MultiLocalTrans.MultiPut(targets, items)
+}
+
+func TestRingGetFallsBackToReplica(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key, WithWriteQuorum(2)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// The first host stops answering pings
+	ft.fail("test")
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		val, err := r2.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+}
+
+// Transport where writes to the vnodes of one host land late
+type lagTrans struct {
+	*MultiLocalTrans
+	host string
+	lag  time.Duration
+}
+
+func (p *lagTrans) Put(v *Vnode, item *Item) error {
+	if v.Host == p.host {
+		time.Sleep(p.lag)
+	}
+	return p.MultiLocalTrans.Put(v, item)
+}
+
+func TestRingGetSeesOwnWrite(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	lt := &lagTrans{MultiLocalTrans: ml, host: "test2", lag: 100 * time.Millisecond}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), lt)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), lt, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// With the default quorums, a read right after a write sees it,
+	// even before the write reached the lagging replica
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		val, err := r.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+}
+
+func TestRingSurvivesHostLoss(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key, WithWriteQuorum(2)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Lose the first host without leaving
+	ml.Deregister("test")
+	r.Shutdown()
+
+	// Wait for the ring to route around it
+	<-time.After(200 * time.Millisecond)
+
+	// Every key is still readable from its replica
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		val, err := r2.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+}
+
+func TestRingQuorum(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Both replicas are up
+	if err := r.Put([]byte("foo"), []byte("bar"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := r.Get([]byte("foo"), WithReadQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// There are only two hosts to replicate to
+	_, err = r.Get([]byte("foo"), WithReadQuorum(3))
+	if _, ok := err.(*QuorumError); !ok {
+		t.Fatalf("expected quorum error, got %v", err)
+	}
+
+	// One replica fails
+	ft.fail("test2")
+	err = r.Put([]byte("foo"), []byte("baz"), WithWriteQuorum(2))
+	qerr, ok := err.(*QuorumError)
+	if !ok {
+		t.Fatalf("expected quorum error, got %v", err)
+	}
+	if qerr.Required != 2 || qerr.Acks > 1 {
+		t.Fatalf("bad quorum error: %v", qerr)
+	}
+	if _, err := r.Get([]byte("foo"), WithReadQuorum(2)); err == nil {
+		t.Fatalf("expected err!")
+	}
+
+	// A single replica is enough by default
+	if err := r.Put([]byte("foo"), []byte("baz")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	val, err := r.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("baz")) {
+		t.Fatalf("bad value: %s", val)
+	}
+}
+
+func TestRingQuorumOwnerDown(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Reads needing every replica fail while the owner is down,
+	// without leaving any routine behind
+	numGo := runtime.NumGoroutine()
+	ft.fail("test")
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if _, err := r2.Get(key, WithReadQuorum(2)); err == nil {
+			t.Fatalf("expected err for %s", key)
+		}
+	}
+	after := settleGoroutines(numGo)
+	if after > numGo {
+		t.Fatalf("unexpected routines! A:%d B:%d", after, numGo)
+	}
+}
+
+func TestRingConcurrentPuts(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Later writes through the same host supersede earlier ones
+	key := []byte("foo")
+	r.Put(key, []byte("a"), WithWriteQuorum(2))
+	r.Put(key, []byte("b"), WithWriteQuorum(2))
+	val, err := r2.Get(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("b")) {
+		t.Fatalf("bad value: %s", val)
+	}
+
+	// A write through another host without a context conflicts
+	r2.Put(key, []byte("c"), WithWriteQuorum(2))
+	_, err = r.Get(key, WithReadQuorum(2))
+	cerr, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if len(cerr.Values) != 2 {
+		t.Fatalf("expected 2 siblings, got %d", len(cerr.Values))
+	}
+
+	// Writing with the context resolves the conflict
+	if err := r.Put(key, []byte("d"), WithContext(cerr.Context), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	values, _, err := r2.GetVersions(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(values) != 1 || !bytes.Equal(values[0], []byte("d")) {
+		t.Fatalf("bad values: %q", values)
+	}
+}
+
+func TestRingReadRepair(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	key := []byte("foo")
+	if err := r.Put(key, []byte("bar"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	replicas, err := r.replicas(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(replicas) != 2 {
+		t.Fatalf("expected 2 replicas, got %d", len(replicas))
+	}
+
+	// Lose the copy on the second replica
+	if err := ringVnode(replicas[1], r, r2).Store.Delete(key); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// A quorum read repairs it in the background
+	val, err := r.Get(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("bad value: %s", val)
+	}
+	<-time.After(50 * time.Millisecond)
+	if _, err := ml.Get(replicas[1], key); err != nil {
+		t.Fatalf("expected replica to be repaired. %s", err)
+	}
+	if s := r.Stats(); s.ReadRepairs != 1 || s.ReadRepairFailures != 0 {
+		t.Fatalf("bad stats: %+v", s)
+	}
+
+	// Consistent replicas need no repair
+	r.Get(key, WithReadQuorum(2))
+	<-time.After(50 * time.Millisecond)
+	if s := r.Stats(); s.ReadRepairs != 1 {
+		t.Fatalf("bad stats: %+v", s)
+	}
+}
@@ -0,0 +1,74 @@
+package chord
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+/*
+Logger receives the messages logged by the ring. Each message comes
+with structured fields, given as alternating keys and values, such as
+the vnode and host it is about, or the RPC type and its latency. The
+methods match those of *slog.Logger, which SlogLogger adapts.
+*/
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// Discards every message, used when no Logger is configured
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, fields ...interface{}) {}
+func (noopLogger) Info(msg string, fields ...interface{})  {}
+func (noopLogger) Warn(msg string, fields ...interface{})  {}
+func (noopLogger) Error(msg string, fields ...interface{}) {}
+
+// Writes the messages to a slog.Logger
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// SlogLogger returns a Logger writing to l, or to slog.Default() if nil
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{l}
+}
+
+func (s *slogLogger) Debug(msg string, fields ...interface{}) {
+	s.l.Debug(msg, fields...)
+}
+
+func (s *slogLogger) Info(msg string, fields ...interface{}) {
+	s.l.Info(msg, fields...)
+}
+
+func (s *slogLogger) Warn(msg string, fields ...interface{}) {
+	s.l.Warn(msg, fields...)
+}
+
+func (s *slogLogger) Error(msg string, fields ...interface{}) {
+	s.l.Error(msg, fields...)
+}
+
+// Implemented by transports that log, to be given Config.Logger
+type loggerSetter interface {
+	SetLogger(Logger)
+}
+
+// Returns the logger of a config, discarding messages if unset
+func configLogger(conf *Config) Logger {
+	if conf == nil || conf.Logger == nil {
+		return noopLogger{}
+	}
+	return conf.Logger
+}
+
+// Returns the fields identifying the vnode, followed by the given ones
+func (vn *Vnode) logFields(fields ...interface{}) []interface{} {
+	return append([]interface{}{"vnode", fmt.Sprintf("%x", vn.Id), "host", vn.Host}, fields...)
+}
@@ -0,0 +1,117 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the logged messages, with their level and fields
+type testLogger struct {
+	lock sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) log(level, msg string, fields []interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf("%s %s %v", level, msg, fields))
+}
+
+func (l *testLogger) Debug(msg string, fields ...interface{}) { l.log("DEBUG", msg, fields) }
+func (l *testLogger) Info(msg string, fields ...interface{})  { l.log("INFO", msg, fields) }
+func (l *testLogger) Warn(msg string, fields ...interface{})  { l.log("WARN", msg, fields) }
+func (l *testLogger) Error(msg string, fields ...interface{}) { l.log("ERROR", msg, fields) }
+
+// Checks if a message containing s was logged
+func (l *testLogger) logged(s string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, msg := range l.msgs {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := SlogLogger(slog.New(h))
+
+	vn := &Vnode{Id: []byte{0xab, 0xcd}, Host: "test"}
+	logger.Debug("hidden")
+	logger.Error("Failed", vn.logFields("error", "boom")...)
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("unexpected debug message: %s", out)
+	}
+	if !strings.Contains(out, "level=ERROR msg=Failed vnode=abcd host=test error=boom") {
+		t.Fatalf("bad output: %s", out)
+	}
+	if SlogLogger(nil) == nil {
+		t.Fatalf("expected the default logger")
+	}
+}
+
+func TestRingLogger(t *testing.T) {
+	logger := &testLogger{}
+	conf := fastConf()
+	conf.Logger = logger
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !logger.logged("INFO Created ring [host test vnodes 8]") {
+		t.Fatalf("expected the ring creation to be logged")
+	}
+	if !logger.logged("DEBUG Registered local vnode [vnode") {
+		t.Fatalf("expected the vnodes to be logged")
+	}
+	if err := r.Leave(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !logger.logged("INFO Left ring [host test keysLeft 0]") {
+		t.Fatalf("expected leaving to be logged")
+	}
+}
+
+func TestTCPLogger(t *testing.T) {
+	listen := "localhost:10047"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	// The ring gives its logger to the transport
+	logger := &testLogger{}
+	conf.Logger = logger
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	if ok, err := trans.Ping(&r.Vnodes[0].Vnode); !ok || err != nil {
+		t.Fatalf("unexpected result. %v %v", ok, err)
+	}
+
+	// The RPC is logged once the response is sent
+	<-time.After(50 * time.Millisecond)
+	if !logger.logged("DEBUG Handled RPC [rpc Ping remote") {
+		t.Fatalf("expected the RPC to be logged")
+	}
+	if !logger.logged("latency") {
+		t.Fatalf("expected the latency to be logged")
+	}
+}
@@ -0,0 +1,318 @@
+package chord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"time"
+)
+
+const (
+	// Depth of the Merkle trees compared during anti-entropy. Items
+	// are placed in one of 2^merkleDepth leaves by their hash.
+	merkleDepth  = 8
+	merkleLeaves = 1 << merkleDepth
+	merkleNodes  = 2*merkleLeaves - 1
+
+	// Time between anti-entropy rounds, if not configured
+	defaultAntiEntropyInterval = time.Minute
+)
+
+// KeyDigest summarizes the versions stored under a key, so replicas can
+// find the keys they disagree on without sending the values
+type KeyDigest struct {
+	Key    []byte
+	Digest []byte
+}
+
+// A Merkle tree over the items in a hash range, stored as the node
+// hashes in heap order. Node i has children 2i+1 and 2i+2, and the
+// hash of an empty subtree is nil.
+type merkleTree [][]byte
+
+// Builds the Merkle tree of a set of items
+func buildMerkle(hf func() hash.Hash, items []*Item) merkleTree {
+	// Group the digests by leaf, ordered by key
+	digests := make([][]*KeyDigest, merkleLeaves)
+	for _, item := range items {
+		leaf := merkleLeaf(item.Hash)
+		digests[leaf] = append(digests[leaf], itemDigest(hf, item))
+	}
+
+	tree := make(merkleTree, merkleNodes)
+	for leaf, kds := range digests {
+		if len(kds) == 0 {
+			continue
+		}
+		sortDigests(kds)
+		h := hf()
+		for _, kd := range kds {
+			h.Write(kd.Key)
+			h.Write(kd.Digest)
+		}
+		tree[merkleLeaves-1+leaf] = h.Sum(nil)
+	}
+
+	// Hash the inner nodes, bottom up
+	for i := merkleLeaves - 2; i >= 0; i-- {
+		left, right := tree[2*i+1], tree[2*i+2]
+		if left == nil && right == nil {
+			continue
+		}
+		h := hf()
+		h.Write(left)
+		h.Write(right)
+		tree[i] = h.Sum(nil)
+	}
+	return tree
+}
+
+// Returns the leaf holding a key hash
+func merkleLeaf(keyHash []byte) int {
+	if len(keyHash) == 0 {
+		return 0
+	}
+	return int(keyHash[0]) >> (8 - merkleDepth)
+}
+
+// Checks if a node of the tree is a leaf
+func isMerkleLeaf(node int) bool {
+	return node >= merkleLeaves-1
+}
+
+// Digests the versions of an item, independent of the order of its siblings
+func itemDigest(hf func() hash.Hash, item *Item) *KeyDigest {
+	var encoded [][]byte
+	for _, v := range item.versions() {
+		encoded = append(encoded, encodeVersion(v))
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	h := hf()
+	binary.Write(h, binary.BigEndian, item.Version)
+	for _, e := range encoded {
+		h.Write(e)
+	}
+	return &KeyDigest{Key: item.Key, Digest: h.Sum(nil)}
+}
+
+// Encodes a version with its clock entries in a fixed order
+func encodeVersion(v *Version) []byte {
+	hosts := make([]string, 0, len(v.Clock))
+	for host := range v.Clock {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var buf bytes.Buffer
+	for _, host := range hosts {
+		binary.Write(&buf, binary.BigEndian, uint32(len(host)))
+		buf.WriteString(host)
+		binary.Write(&buf, binary.BigEndian, v.Clock[host])
+	}
+	binary.Write(&buf, binary.BigEndian, unixNano(v.Deleted))
+	binary.Write(&buf, binary.BigEndian, unixNano(v.Expires))
+	binary.Write(&buf, binary.BigEndian, uint32(len(v.Value)))
+	buf.Write(v.Value)
+	return buf.Bytes()
+}
+
+// Sorts digests by key
+func sortDigests(kds []*KeyDigest) {
+	sort.Slice(kds, func(i, j int) bool {
+		return bytes.Compare(kds[i].Key, kds[j].Key) < 0
+	})
+}
+
+// The Merkle tree last built to answer a sync, over (start, end]
+type cachedMerkle struct {
+	start, end []byte
+	tree       merkleTree
+}
+
+// RPC: Returns the hashes of the given nodes of the Merkle
+// tree over our items in (start, end]
+func (vn *LocalVnode) MerkleNodes(start, end []byte, nodes []int) ([][]byte, error) {
+	tree := vn.merkleFor(start, end, nodes)
+	out := make([][]byte, len(nodes))
+	for idx, node := range nodes {
+		if node >= 0 && node < len(tree) {
+			out[idx] = tree[node]
+		}
+	}
+	return out, nil
+}
+
+// Returns the Merkle tree over (start, end]. A sync asks for the root
+// first and then descends a level at a time, so the tree is built when
+// the root is asked for, and kept for the levels below it.
+func (vn *LocalVnode) merkleFor(start, end []byte, nodes []int) merkleTree {
+	vn.merkleLock.Lock()
+	defer vn.merkleLock.Unlock()
+	c := vn.merkleCache
+	root := len(nodes) > 0 && nodes[0] == 0
+	if root || c == nil || !bytes.Equal(c.start, start) || !bytes.Equal(c.end, end) {
+		tree := buildMerkle(vn.Ring.config.HashFunc, vn.collectRange(start, end, 0))
+		c = &cachedMerkle{start: start, end: end, tree: tree}
+		vn.merkleCache = c
+	}
+	return c.tree
+}
+
+// RPC: Returns the digests of our items in (start, end]
+// that fall in the given Merkle tree leaves
+func (vn *LocalVnode) KeyDigests(start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	return vn.keyDigests(start, end, leaves), nil
+}
+
+// Digests the items in (start, end] that fall in the given leaves
+func (vn *LocalVnode) keyDigests(start, end []byte, leaves []int) []*KeyDigest {
+	want := make(map[int]bool, len(leaves))
+	for _, leaf := range leaves {
+		want[leaf] = true
+	}
+	var kds []*KeyDigest
+	for _, item := range vn.collectRange(start, end, 0) {
+		if want[merkleLeaf(item.Hash)] {
+			kds = append(kds, itemDigest(vn.Ring.config.HashFunc, item))
+		}
+	}
+	sortDigests(kds)
+	return kds
+}
+
+// Runs a round of anti-entropy if one is due
+func (vn *LocalVnode) maybeAntiEntropy() error {
+	interval := vn.Ring.config.AntiEntropyInterval
+	if interval <= 0 {
+		interval = defaultAntiEntropyInterval
+	}
+	if time.Since(vn.lastAntiEntropy) < interval {
+		return nil
+	}
+	vn.lastAntiEntropy = time.Now()
+	return vn.antiEntropy()
+}
+
+// Compares the items in our owned range with each of the successors
+// replicating it, exchanging the keys we disagree on
+func (vn *LocalVnode) antiEntropy() error {
+	pred := vn.predecessor()
+	if pred == nil || vn.left.Load() {
+		return nil
+	}
+	vn.Ring.stats.antiEntropyRounds.Add(1)
+	var err error
+	for _, target := range vn.replicaSuccessors() {
+		err = mergeErrors(err, vn.syncReplica(target, pred.Id, vn.Id))
+	}
+	return err
+}
+
+// Returns the successors that replicate our owned range, which
+// are the next ones on distinct hosts other than our own
+func (vn *LocalVnode) replicaSuccessors() []*Vnode {
+	n := replicationFactor(vn.Ring.config) - 1
+	hosts := map[string]bool{vn.Host: true}
+	var out []*Vnode
+	for _, s := range vn.successors() {
+		if len(out) == n {
+			break
+		}
+		if s == nil || hosts[s.Host] {
+			continue
+		}
+		out = append(out, s)
+		hosts[s.Host] = true
+	}
+	return out
+}
+
+// Brings a replica and us in sync over (start, end]. The Merkle trees
+// are compared level by level to find the leaves that differ, and only
+// the keys in those leaves that differ are exchanged.
+func (vn *LocalVnode) syncReplica(target *Vnode, start, end []byte) error {
+	trans := vn.Ring.transport
+	local := buildMerkle(vn.Ring.config.HashFunc, vn.collectRange(start, end, 0))
+
+	// Descend the trees along the nodes that differ
+	var leaves []int
+	nodes := []int{0}
+	for len(nodes) > 0 {
+		remote, err := trans.MerkleNodes(target, start, end, nodes)
+		if err != nil {
+			return err
+		}
+		var next []int
+		for idx, node := range nodes {
+			if idx < len(remote) && bytes.Equal(local[node], remote[idx]) {
+				continue
+			}
+			if isMerkleLeaf(node) {
+				leaves = append(leaves, node-(merkleLeaves-1))
+			} else {
+				next = append(next, 2*node+1, 2*node+2)
+			}
+		}
+		nodes = next
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	// Find the keys that differ within those leaves
+	remote, err := trans.KeyDigests(target, start, end, leaves)
+	if err != nil {
+		return err
+	}
+	theirs := make(map[string][]byte, len(remote))
+	for _, kd := range remote {
+		theirs[string(kd.Key)] = kd.Digest
+	}
+	var push, pull [][]byte
+	for _, kd := range vn.keyDigests(start, end, leaves) {
+		digest, ok := theirs[string(kd.Key)]
+		delete(theirs, string(kd.Key))
+		if ok && bytes.Equal(digest, kd.Digest) {
+			continue
+		}
+		push = append(push, kd.Key)
+		if ok {
+			pull = append(pull, kd.Key)
+		}
+	}
+	for key := range theirs {
+		pull = append(pull, []byte(key))
+	}
+
+	// Send our versions, and merge in theirs
+	for _, key := range push {
+		item, getErr := vn.Store.Get(key)
+		if getErr == nil {
+			getErr = trans.Put(target, item)
+		}
+		if getErr == ErrKeyNotFound {
+			continue
+		}
+		err = mergeErrors(err, getErr)
+		if getErr == nil {
+			vn.Ring.stats.antiEntropyKeys.Add(1)
+		}
+	}
+	for _, key := range pull {
+		item, getErr := trans.Get(target, key)
+		if getErr == nil {
+			getErr = vn.merge(item, false)
+		}
+		if getErr == ErrKeyNotFound {
+			continue
+		}
+		err = mergeErrors(err, getErr)
+		if getErr == nil {
+			vn.Ring.stats.antiEntropyKeys.Add(1)
+		}
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package chord
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Transport failing the Merkle tree requests to other hosts
+type merkleFailTrans struct {
+	*MultiLocalTrans
+}
+
+func (m *merkleFailTrans) MerkleNodes(target *Vnode, start, end []byte, nodes []int) ([][]byte, error) {
+	return nil, fmt.Errorf("merkle failed")
+}
+
+func merkleItems(n int) []*Item {
+	var items []*Item
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		h := sha1.Sum(key)
+		items = append(items, &Item{Key: key, Hash: h[:], Value: key,
+			Clock: VectorClock{"test": uint64(i)}})
+	}
+	return items
+}
+
+func TestBuildMerkle(t *testing.T) {
+	a := buildMerkle(sha1.New, merkleItems(100))
+	b := buildMerkle(sha1.New, merkleItems(100))
+	if !bytes.Equal(a[0], b[0]) {
+		t.Fatalf("expected equal roots")
+	}
+
+	// Change a single item
+	items := merkleItems(100)
+	items[7] = &Item{Key: items[7].Key, Hash: items[7].Hash, Value: []byte("changed"),
+		Clock: VectorClock{"test": 100}}
+	c := buildMerkle(sha1.New, items)
+	if bytes.Equal(a[0], c[0]) {
+		t.Fatalf("expected roots to differ")
+	}
+	var diff []int
+	for leaf := 0; leaf < merkleLeaves; leaf++ {
+		if !bytes.Equal(a[merkleLeaves-1+leaf], c[merkleLeaves-1+leaf]) {
+			diff = append(diff, leaf)
+		}
+	}
+	if len(diff) != 1 || diff[0] != merkleLeaf(items[7].Hash) {
+		t.Fatalf("bad differing leaves: %v", diff)
+	}
+
+	// Empty trees match
+	if buildMerkle(sha1.New, nil)[0] != nil {
+		t.Fatalf("expected empty root")
+	}
+}
+
+func TestItemDigestSiblingOrder(t *testing.T) {
+	x := &Version{Value: []byte("x"), Clock: VectorClock{"a": 1}}
+	y := &Version{Value: []byte("y"), Clock: VectorClock{"b": 1}}
+	a := &Item{Key: []byte("foo"), Value: x.Value, Clock: x.Clock, Siblings: []*Version{y}}
+	b := &Item{Key: []byte("foo"), Value: y.Value, Clock: y.Clock, Siblings: []*Version{x}}
+	if !bytes.Equal(itemDigest(sha1.New, a).Digest, itemDigest(sha1.New, b).Digest) {
+		t.Fatalf("expected equal digests")
+	}
+}
+
+func TestAntiEntropy(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings, syncing often
+	conf := kvConf("test")
+	conf.AntiEntropyInterval = 10 * time.Millisecond
+	r, err := Create(conf, ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	conf2 := kvConf("test2")
+	conf2.AntiEntropyInterval = 10 * time.Millisecond
+	r2, err := Join(conf2, ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Write each key to a single replica only
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		replicas, err := r.replicas(key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		item := &Item{Key: key, Value: key, Clock: VectorClock{"test": 1}}
+		if err := ml.Put(replicas[i%len(replicas)], item); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+
+	// Wait for anti-entropy to copy them over
+	<-time.After(200 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		replicas, _ := r.replicas(key)
+		for _, vn := range replicas {
+			if _, err := ml.Get(vn, key); err != nil {
+				t.Fatalf("expected %s on %s. %s", key, vn.String(), err)
+			}
+		}
+	}
+	if r.Stats().AntiEntropyKeys+r2.Stats().AntiEntropyKeys == 0 {
+		t.Fatalf("expected keys to be exchanged")
+	}
+}
+
+func TestMerkleNodesCached(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	vn := r.Vnodes[0]
+	start, end := vn.Id, vn.Id // The whole ring
+	root, err := vn.MerkleNodes(start, end, []int{0})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	cached := vn.merkleCache
+
+	// The levels below the root reuse the tree built for it
+	if _, err := vn.MerkleNodes(start, end, []int{1, 2}); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if vn.merkleCache != cached {
+		t.Fatalf("expected the tree to be reused")
+	}
+
+	// Asking for the root again starts a new sync
+	for _, item := range merkleItems(10) {
+		vn.Store.Put(item)
+	}
+	again, err := vn.MerkleNodes(start, end, []int{0})
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if vn.merkleCache == cached || bytes.Equal(root[0], again[0]) {
+		t.Fatalf("expected the tree to be rebuilt")
+	}
+}
+
+func TestAntiEntropyErrorReported(t *testing.T) {
+	mt := &merkleFailTrans{InitMLTransport()}
+
+	// Create two rings, syncing often
+	logger := &testLogger{}
+	conf := kvConf("test")
+	conf.AntiEntropyInterval = 10 * time.Millisecond
+	conf.Logger = logger
+	r, err := Create(conf, mt)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	conf2 := kvConf("test2")
+	conf2.AntiEntropyInterval = 10 * time.Millisecond
+	r2, err := Join(conf2, mt, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// The failed syncs are logged and count against the health
+	<-time.After(200 * time.Millisecond)
+	if !logger.logged("WARN Error syncing with replicas") {
+		t.Fatalf("expected the error to be logged")
+	}
+	reported := false
+	for _, h := range r.Health() {
+		if h.LastError != nil && strings.Contains(h.LastError.Error(), "Error syncing with replicas") {
+			reported = true
+		}
+	}
+	if !reported {
+		t.Fatalf("expected the error in the health, got %+v", r.Health())
+	}
+}
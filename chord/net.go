@@ -3,7 +3,6 @@ package chord
 import (
 	"encoding/gob"
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -30,6 +29,7 @@ type TCPTransport struct {
 	poolLock sync.Mutex
 	pool     map[string][]*tcpOutConn
 	shutdown int32
+	logger   Logger // Guarded by lock
 }
 
 type tcpOutConn struct {
@@ -49,8 +49,65 @@ const (
 	tcpFindSucReq
 	tcpClearPredReq
 	tcpSkipSucReq
+	tcpGetReq
+	tcpPutReq
+	tcpDeleteReq
+	tcpScanReq
+	tcpTransferReq
+	tcpMerkleNodesReq
+	tcpKeyDigestsReq
+	tcpCASReq
+	tcpIncrementReq
+	tcpAppendReq
+	tcpMultiGetReq
+	tcpMultiPutReq
+	tcpWatchReq
+	tcpUnwatchReq
+	tcpWatchEventReq
+	tcpTxnPrepareReq
+	tcpTxnCommitReq
+	tcpTxnAbortReq
+	tcpTxnStatusReq
 )
 
+// Names of the request types, for logging
+var tcpReqNames = [...]string{
+	tcpPing:           "Ping",
+	tcpListReq:        "ListVnodes",
+	tcpGetPredReq:     "GetPredecessor",
+	tcpNotifyReq:      "Notify",
+	tcpFindSucReq:     "FindSuccessors",
+	tcpClearPredReq:   "ClearPredecessor",
+	tcpSkipSucReq:     "SkipSuccessor",
+	tcpGetReq:         "Get",
+	tcpPutReq:         "Put",
+	tcpDeleteReq:      "Delete",
+	tcpScanReq:        "Scan",
+	tcpTransferReq:    "Transfer",
+	tcpMerkleNodesReq: "MerkleNodes",
+	tcpKeyDigestsReq:  "KeyDigests",
+	tcpCASReq:         "CompareAndSwap",
+	tcpIncrementReq:   "Increment",
+	tcpAppendReq:      "Append",
+	tcpMultiGetReq:    "MultiGet",
+	tcpMultiPutReq:    "MultiPut",
+	tcpWatchReq:       "Watch",
+	tcpUnwatchReq:     "Unwatch",
+	tcpWatchEventReq:  "WatchEvent",
+	tcpTxnPrepareReq:  "TxnPrepare",
+	tcpTxnCommitReq:   "TxnCommit",
+	tcpTxnAbortReq:    "TxnAbort",
+	tcpTxnStatusReq:   "TxnStatus",
+}
+
+// Returns the name of a request type
+func tcpReqName(reqType int) string {
+	if reqType < 0 || reqType >= len(tcpReqNames) {
+		return fmt.Sprintf("Unknown(%d)", reqType)
+	}
+	return tcpReqNames[reqType]
+}
+
 type tcpHeader struct {
 	ReqType int
 }
@@ -86,6 +143,158 @@ type tcpBodyBoolError struct {
 	B   bool
 	Err error
 }
+type tcpBodyKey struct {
+	Target *Vnode
+	Key    []byte
+}
+type tcpBodyItem struct {
+	Target *Vnode
+	Item   *Item
+}
+type tcpBodyScan struct {
+	Target *Vnode
+	Start  []byte
+	End    []byte
+	Limit  int
+}
+type tcpBodyItems struct {
+	Target *Vnode
+	Items  []*Item
+}
+type tcpBodyItemsError struct {
+	Items []*Item
+	Err   error
+}
+type tcpBodyMerkle struct {
+	Target *Vnode
+	Start  []byte
+	End    []byte
+	Nodes  []int
+}
+type tcpBodyHashesError struct {
+	Hashes [][]byte
+	Err    error
+}
+type tcpBodyDigestsError struct {
+	Digests []*KeyDigest
+	Err     error
+}
+type tcpBodyItemError struct {
+	Item       *Item
+	NotFound   bool
+	NotCounter bool
+	Err        error
+}
+type tcpBodyCAS struct {
+	Target   *Vnode
+	Item     *Item
+	Expected uint64
+	Absent   bool
+}
+type tcpBodyIncrement struct {
+	Target *Vnode
+	Key    []byte
+	Delta  int64
+}
+type tcpBodyAppend struct {
+	Target *Vnode
+	Key    []byte
+	Data   []byte
+}
+type tcpBodyMultiKey struct {
+	Targets []*Vnode
+	Keys    [][]byte
+}
+type tcpBodyMultiItem struct {
+	Targets []*Vnode
+	Items   []*Item
+}
+type tcpBodyMultiItemError struct {
+	Items []*Item // Items found, gob cannot send nil entries
+	Found []bool
+	Err   error
+}
+type tcpBodyMultiError struct {
+	Errs   []error // Errors of the failed items, gob cannot send nil entries
+	Failed []bool
+	Err    error
+}
+type tcpBodyCASError struct {
+	Item     *Item
+	Conflict *CASError
+	Err      error
+}
+type tcpBodyWatch struct {
+	Target     *Vnode
+	Key        []byte
+	Id         string
+	Subscriber *Vnode
+}
+type tcpBodyWatchEvent struct {
+	Target *Vnode
+	Id     string
+	Event  *WatchEvent
+}
+type tcpBodyTxnPrepare struct {
+	Target      *Vnode
+	Id          string
+	Coordinator *Vnode
+	Items       []*Item
+	Timeout     time.Duration
+}
+type tcpBodyTxn struct {
+	Target *Vnode
+	Id     string
+}
+type tcpBodyTxnStateError struct {
+	State TxnState
+	Err   error
+}
+
+// Errors are sent over the wire as a tcpError, since gob
+// cannot encode the unexported types made by fmt.Errorf
+type tcpError struct {
+	Msg string
+}
+
+func (e *tcpError) Error() string {
+	return e.Msg
+}
+
+func init() {
+	gob.Register(&tcpError{})
+}
+
+// Converts an error into a form that can be gob encoded
+func wireError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*tcpError); ok {
+		return err
+	}
+	return &tcpError{Msg: err.Error()}
+}
+
+// Errors that callers check for, whose identity is restored
+// once they were sent using wireError
+var wireSentinels = []error{ErrKeyNotFound, ErrKeyLocked, ErrNotCounter, ErrCounterOverflow,
+	errVnodeLeft, errTxnUnknown}
+
+// Restores the identity of an error that callers check for,
+// once it was sent using wireError
+func unwireError(err error) error {
+	terr, ok := err.(*tcpError)
+	if !ok {
+		return err
+	}
+	for _, sentinel := range wireSentinels {
+		if terr.Msg == sentinel.Error() {
+			return sentinel
+		}
+	}
+	return err
+}
 
 // Creates a new TCP transport on the given listen address with the
 // configured timeout duration.
@@ -110,7 +319,8 @@ func InitTCPTransport(listen string, timeout time.Duration) (*TCPTransport, erro
 		maxIdle: maxIdle,
 		local:   local,
 		inbound: inbound,
-		pool:    pool}
+		pool:    pool,
+		logger:  noopLogger{}}
 
 	// Listen for connections
 	go tcp.listen()
@@ -124,7 +334,7 @@ func InitTCPTransport(listen string, timeout time.Duration) (*TCPTransport, erro
 
 // Checks for a local vnode
 func (t *TCPTransport) get(vn *Vnode) (VnodeRPC, bool) {
-	key := vn.String()
+	key := vn.ident()
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 	w, ok := t.local[key]
@@ -135,6 +345,25 @@ func (t *TCPTransport) get(vn *Vnode) (VnodeRPC, bool) {
 	}
 }
 
+// SetLogger sets the logger receiving the errors and the RPCs
+// handled by the transport, which are discarded by default. Create
+// and Join set it to Config.Logger, if one is configured.
+func (t *TCPTransport) SetLogger(l Logger) {
+	if l == nil {
+		l = noopLogger{}
+	}
+	t.lock.Lock()
+	t.logger = l
+	t.lock.Unlock()
+}
+
+// Returns the logger of the transport
+func (t *TCPTransport) log() Logger {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.logger
+}
+
 // Gets an outbound connection to a host
 func (t *TCPTransport) getConn(host string) (*tcpOutConn, error) {
 	// Check if we have a conn cached
@@ -550,19 +779,286 @@ func (t *TCPTransport) SkipSuccessor(target, self *Vnode) error {
 	}
 }
 
-func (t *TCPTransport) PutKey(vn *Vnode, value int) error  {
-	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
-	//vn.PutKey(90)
-	//return nil
+// Read the item stored under a key on a vnode
+func (t *TCPTransport) Get(target *Vnode, key []byte) (*Item, error) {
+	body := tcpBodyKey{Target: target, Key: key}
+	resp := tcpBodyItemError{}
+	if err := t.roundTrip(target.Host, tcpGetReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.NotFound {
+		return nil, ErrKeyNotFound
+	}
+	return resp.Item, unwireError(resp.Err)
+}
+
+// Store a versioned item on a vnode
+func (t *TCPTransport) Put(target *Vnode, item *Item) error {
+	body := tcpBodyItem{Target: target, Item: item}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpPutReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// Remove a key stored on a vnode
+func (t *TCPTransport) Delete(target *Vnode, key []byte) error {
+	body := tcpBodyKey{Target: target, Key: key}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpDeleteReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// List up to limit items on a vnode with hashes in (start, end]
+func (t *TCPTransport) Scan(target *Vnode, start, end []byte, limit int) ([]*Item, error) {
+	body := tcpBodyScan{Target: target, Start: start, End: end, Limit: limit}
+	resp := tcpBodyItemsError{}
+	if err := t.roundTrip(target.Host, tcpScanReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Items, unwireError(resp.Err)
+}
+
+// Hand over items for a vnode to store
+func (t *TCPTransport) Transfer(target *Vnode, items []*Item) error {
+	body := tcpBodyItems{Target: target, Items: items}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpTransferReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// Read the items stored under keys on vnodes of a single host
+func (t *TCPTransport) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	body := tcpBodyMultiKey{Targets: targets, Keys: keys}
+	resp := tcpBodyMultiItemError{}
+	if err := t.roundTrip(targets[0].Host, tcpMultiGetReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, unwireError(resp.Err)
+	}
+	if len(resp.Found) != len(targets) {
+		return nil, fmt.Errorf("Bad response! Got %d results for %d keys", len(resp.Found), len(targets))
+	}
+	items := make([]*Item, len(resp.Found))
+	for idx, found := range resp.Found {
+		if !found {
+			continue
+		} else if len(resp.Items) == 0 {
+			return nil, fmt.Errorf("Bad response! Missing found items")
+		}
+		items[idx], resp.Items = resp.Items[0], resp.Items[1:]
+	}
+	return items, nil
+}
+
+// Store versioned items on vnodes of a single host
+func (t *TCPTransport) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	body := tcpBodyMultiItem{Targets: targets, Items: items}
+	resp := tcpBodyMultiError{}
+	if err := t.roundTrip(targets[0].Host, tcpMultiPutReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, unwireError(resp.Err)
+	}
+	if len(resp.Failed) != len(targets) {
+		return nil, fmt.Errorf("Bad response! Got %d results for %d items", len(resp.Failed), len(targets))
+	}
+	errs := make([]error, len(resp.Failed))
+	for idx, failed := range resp.Failed {
+		if !failed {
+			continue
+		} else if len(resp.Errs) == 0 {
+			return nil, fmt.Errorf("Bad response! Missing item errors")
+		}
+		errs[idx], resp.Errs = unwireError(resp.Errs[0]), resp.Errs[1:]
+	}
+	return errs, nil
+}
+
+// Store an item on a vnode if the key is at the expected version, or absent
+func (t *TCPTransport) CompareAndSwap(target *Vnode, item *Item, expected uint64, absent bool) (*Item, error) {
+	body := tcpBodyCAS{Target: target, Item: item, Expected: expected, Absent: absent}
+	resp := tcpBodyCASError{}
+	if err := t.roundTrip(target.Host, tcpCASReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Conflict != nil {
+		return nil, resp.Conflict
+	}
+	return resp.Item, unwireError(resp.Err)
+}
+
+// Add to the counter stored under a key on a vnode
+func (t *TCPTransport) Increment(target *Vnode, key []byte, delta int64) (*Item, error) {
+	body := tcpBodyIncrement{Target: target, Key: key, Delta: delta}
+	resp := tcpBodyItemError{}
+	if err := t.roundTrip(target.Host, tcpIncrementReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.NotCounter {
+		return nil, ErrNotCounter
+	}
+	return resp.Item, unwireError(resp.Err)
+}
+
+// Add data to the end of the value stored under a key on a vnode
+func (t *TCPTransport) Append(target *Vnode, key, data []byte) (*Item, error) {
+	body := tcpBodyAppend{Target: target, Key: key, Data: data}
+	resp := tcpBodyItemError{}
+	if err := t.roundTrip(target.Host, tcpAppendReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Item, unwireError(resp.Err)
+}
+
+// Subscribe to the changes of a key on the vnode owning it
+func (t *TCPTransport) Watch(target *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error) {
+	body := tcpBodyWatch{Target: target, Key: key, Id: id, Subscriber: subscriber}
+	resp := tcpBodyItemError{}
+	if err := t.roundTrip(target.Host, tcpWatchReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Item, unwireError(resp.Err)
+}
+
+// Unsubscribe from the changes of a key
+func (t *TCPTransport) Unwatch(target *Vnode, key []byte, id string) error {
+	body := tcpBodyWatch{Target: target, Key: key, Id: id}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpUnwatchReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// Push a change to a watch held by the host of a vnode
+func (t *TCPTransport) WatchEvent(target *Vnode, id string, ev *WatchEvent) (bool, error) {
+	body := tcpBodyWatchEvent{Target: target, Id: id, Event: ev}
+	resp := tcpBodyBoolError{}
+	if err := t.roundTrip(target.Host, tcpWatchEventReq, &body, &resp); err != nil {
+		return false, err
+	}
+	return resp.B, unwireError(resp.Err)
+}
+
+// Lock keys on a vnode and hold writes for a transaction
+func (t *TCPTransport) TxnPrepare(target *Vnode, id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	body := tcpBodyTxnPrepare{Target: target, Id: id, Coordinator: coordinator, Items: items, Timeout: timeout}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpTxnPrepareReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// Make the writes held for a transaction on a vnode
+func (t *TCPTransport) TxnCommit(target *Vnode, id string) ([]*Item, error) {
+	body := tcpBodyTxn{Target: target, Id: id}
+	resp := tcpBodyItemsError{}
+	if err := t.roundTrip(target.Host, tcpTxnCommitReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Items, unwireError(resp.Err)
+}
+
+// Drop the writes held for a transaction on a vnode
+func (t *TCPTransport) TxnAbort(target *Vnode, id string) error {
+	body := tcpBodyTxn{Target: target, Id: id}
+	resp := tcpBodyError{}
+	if err := t.roundTrip(target.Host, tcpTxnAbortReq, &body, &resp); err != nil {
+		return err
+	}
+	return unwireError(resp.Err)
+}
+
+// Ask the coordinator of a transaction for its outcome
+func (t *TCPTransport) TxnStatus(target *Vnode, id string) (TxnState, error) {
+	body := tcpBodyTxn{Target: target, Id: id}
+	resp := tcpBodyTxnStateError{}
+	if err := t.roundTrip(target.Host, tcpTxnStatusReq, &body, &resp); err != nil {
+		return TxnPreparing, err
+	}
+	return resp.State, unwireError(resp.Err)
+}
+
+// Get the hashes of nodes of a vnode's Merkle tree over (start, end]
+func (t *TCPTransport) MerkleNodes(target *Vnode, start, end []byte, nodes []int) ([][]byte, error) {
+	body := tcpBodyMerkle{Target: target, Start: start, End: end, Nodes: nodes}
+	resp := tcpBodyHashesError{}
+	if err := t.roundTrip(target.Host, tcpMerkleNodesReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Hashes, unwireError(resp.Err)
 }
 
-func (t *TCPTransport) GetKey(vn *Vnode) (int, error)  {
-	return 0, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+// Get the digests of a vnode's keys in (start, end] within Merkle tree leaves
+func (t *TCPTransport) KeyDigests(target *Vnode, start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	body := tcpBodyMerkle{Target: target, Start: start, End: end, Nodes: leaves}
+	resp := tcpBodyDigestsError{}
+	if err := t.roundTrip(target.Host, tcpKeyDigestsReq, &body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Digests, unwireError(resp.Err)
+}
+
+// Sends a request to a host and decodes the response into resp,
+// giving up once the transport timeout expires
+func (t *TCPTransport) roundTrip(host string, reqType int, body, resp interface{}) error {
+	// Get a conn
+	out, err := t.getConn(host)
+	if err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		// Send the request
+		out.header.ReqType = reqType
+		if err := out.enc.Encode(&out.header); err != nil {
+			errChan <- err
+			return
+		}
+		if err := out.enc.Encode(body); err != nil {
+			errChan <- err
+			return
+		}
+
+		// Read in the response
+		if err := out.dec.Decode(resp); err != nil {
+			errChan <- err
+			return
+		}
+
+		// Return the connection
+		t.returnConn(out)
+		errChan <- nil
+	}()
+
+	select {
+	case <-time.After(t.timeout):
+		return fmt.Errorf("Command timed out!")
+	case err := <-errChan:
+		return err
+	}
 }
 
 // Register for an RPC callbacks
 func (t *TCPTransport) Register(v *Vnode, o VnodeRPC) {
-	key := v.String()
+	key := v.ident()
 	t.lock.Lock()
 	t.local[key] = &localRPC{v, o}
 	t.lock.Unlock()
@@ -626,7 +1122,7 @@ func (t *TCPTransport) listen() {
 		conn, err := t.sock.AcceptTCP()
 		if err != nil {
 			if atomic.LoadInt32(&t.shutdown) == 0 {
-				fmt.Printf("[ERR] Error accepting TCP connection! %s", err)
+				t.log().Error("Failed to accept TCP connection", "error", err)
 				continue
 			} else {
 				return
@@ -659,22 +1155,26 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 	dec := gob.NewDecoder(conn)
 	enc := gob.NewEncoder(conn)
 	header := tcpHeader{}
+	remote := conn.RemoteAddr().String()
 	var sendResp interface{}
 	for {
 		// Get the header
 		if err := dec.Decode(&header); err != nil {
 			if atomic.LoadInt32(&t.shutdown) == 0 && err.Error() != "EOF" {
-				log.Printf("[ERR] Failed to decode TCP header! Got %s", err)
+				t.log().Error("Failed to decode TCP header", "remote", remote, "error", err)
 			}
 			return
 		}
+		start := time.Now()
+		logger := t.log()
+		rpc := tcpReqName(header.ReqType)
 
 		// Read in the body and process request
 		switch header.ReqType {
 		case tcpPing:
 			body := tcpBodyVnode{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -690,7 +1190,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpListReq:
 			body := tcpBodyString{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -710,7 +1210,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpGetPredReq:
 			body := tcpBodyVnode{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -730,7 +1230,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpNotifyReq:
 			body := tcpBodyTwoVnode{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 			if body.Target == nil {
@@ -753,7 +1253,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpFindSucReq:
 			body := tcpBodyFindSuc{}
 			if err := dec.Decode(&body); err != nil {
-				//log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -773,7 +1273,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpClearPredReq:
 			body := tcpBodyTwoVnode{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -791,7 +1291,7 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 		case tcpSkipSucReq:
 			body := tcpBodyTwoVnode{}
 			if err := dec.Decode(&body); err != nil {
-				log.Printf("[ERR] Failed to decode TCP body! Got %s", err)
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
 				return
 			}
 
@@ -806,16 +1306,412 @@ func (t *TCPTransport) handleConn(conn *net.TCPConn) {
 					body.Target.Host, body.Target.String())
 			}
 
+		case tcpGetReq:
+			body := tcpBodyKey{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemError{}
+			sendResp = &resp
+			if ok {
+				item, err := obj.Get(body.Key)
+				resp.Item = item
+				if err == ErrKeyNotFound {
+					resp.NotFound = true
+				} else {
+					resp.Err = wireError(err)
+				}
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpPutReq:
+			body := tcpBodyItem{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.Put(body.Item))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpDeleteReq:
+			body := tcpBodyKey{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.Delete(body.Key))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpScanReq:
+			body := tcpBodyScan{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemsError{}
+			sendResp = &resp
+			if ok {
+				items, err := obj.Scan(body.Start, body.End, body.Limit)
+				resp.Items = items
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpTransferReq:
+			body := tcpBodyItems{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.Transfer(body.Items))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpMerkleNodesReq:
+			body := tcpBodyMerkle{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyHashesError{}
+			sendResp = &resp
+			if ok {
+				hashes, err := obj.MerkleNodes(body.Start, body.End, body.Nodes)
+				resp.Hashes = hashes
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpKeyDigestsReq:
+			body := tcpBodyMerkle{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyDigestsError{}
+			sendResp = &resp
+			if ok {
+				digests, err := obj.KeyDigests(body.Start, body.End, body.Nodes)
+				resp.Digests = digests
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpMultiGetReq:
+			body := tcpBodyMultiKey{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			resp := tcpBodyMultiItemError{Found: make([]bool, len(body.Keys))}
+			sendResp = &resp
+			for idx, target := range body.Targets {
+				obj, ok := t.get(target)
+				if !ok {
+					resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+						target.Host, target.String()))
+					break
+				}
+				item, err := obj.Get(body.Keys[idx])
+				if err == ErrKeyNotFound {
+					continue
+				} else if err != nil {
+					resp.Err = wireError(err)
+					break
+				}
+				resp.Items = append(resp.Items, item)
+				resp.Found[idx] = true
+			}
+			if resp.Err != nil {
+				resp.Items, resp.Found = nil, nil
+			}
+
+		case tcpMultiPutReq:
+			body := tcpBodyMultiItem{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response, with the error of each item
+			resp := tcpBodyMultiError{Failed: make([]bool, len(body.Items))}
+			sendResp = &resp
+			for idx, target := range body.Targets {
+				var err error
+				if obj, ok := t.get(target); ok {
+					err = obj.Put(body.Items[idx])
+				} else {
+					err = fmt.Errorf("Target VN not found! Target %s:%s",
+						target.Host, target.String())
+				}
+				if err != nil {
+					resp.Errs = append(resp.Errs, wireError(err))
+					resp.Failed[idx] = true
+				}
+			}
+
+		case tcpCASReq:
+			body := tcpBodyCAS{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyCASError{}
+			sendResp = &resp
+			if ok {
+				item, err := obj.CompareAndSwap(body.Item, body.Expected, body.Absent)
+				resp.Item = item
+				if cerr, isCAS := err.(*CASError); isCAS {
+					resp.Conflict = cerr
+				} else {
+					resp.Err = wireError(err)
+				}
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpIncrementReq:
+			body := tcpBodyIncrement{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemError{}
+			sendResp = &resp
+			if ok {
+				item, err := obj.Increment(body.Key, body.Delta)
+				resp.Item = item
+				if err == ErrNotCounter {
+					resp.NotCounter = true
+				} else {
+					resp.Err = wireError(err)
+				}
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpAppendReq:
+			body := tcpBodyAppend{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemError{}
+			sendResp = &resp
+			if ok {
+				item, err := obj.Append(body.Key, body.Data)
+				resp.Item = item
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpWatchReq:
+			body := tcpBodyWatch{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemError{}
+			sendResp = &resp
+			if ok {
+				item, err := obj.Watch(body.Key, body.Id, body.Subscriber)
+				resp.Item = item
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpUnwatchReq:
+			body := tcpBodyWatch{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.Unwatch(body.Key, body.Id))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpWatchEventReq:
+			body := tcpBodyWatchEvent{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyBoolError{}
+			sendResp = &resp
+			if ok {
+				open, err := obj.WatchEvent(body.Id, body.Event)
+				resp.B = open
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpTxnPrepareReq:
+			body := tcpBodyTxnPrepare{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.TxnPrepare(body.Id, body.Coordinator, body.Items, body.Timeout))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpTxnCommitReq:
+			body := tcpBodyTxn{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyItemsError{}
+			sendResp = &resp
+			if ok {
+				items, err := obj.TxnCommit(body.Id)
+				resp.Items = items
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpTxnAbortReq:
+			body := tcpBodyTxn{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyError{}
+			sendResp = &resp
+			if ok {
+				resp.Err = wireError(obj.TxnAbort(body.Id))
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
+		case tcpTxnStatusReq:
+			body := tcpBodyTxn{}
+			if err := dec.Decode(&body); err != nil {
+				logger.Error("Failed to decode TCP body", "rpc", rpc, "remote", remote, "error", err)
+				return
+			}
+
+			// Generate a response
+			obj, ok := t.get(body.Target)
+			resp := tcpBodyTxnStateError{}
+			sendResp = &resp
+			if ok {
+				state, err := obj.TxnStatus(body.Id)
+				resp.State = state
+				resp.Err = wireError(err)
+			} else {
+				resp.Err = wireError(fmt.Errorf("Target VN not found! Target %s:%s",
+					body.Target.Host, body.Target.String()))
+			}
+
 		default:
-			log.Printf("[ERR] Unknown request type! Got %d", header.ReqType)
+			logger.Error("Unknown request type", "rpc", rpc, "remote", remote)
 			return
 		}
 
 		// Send the response
 		if err := enc.Encode(sendResp); err != nil {
-			log.Printf("[ERR] Failed to send TCP body! Got %s", err)
+			logger.Error("Failed to send TCP body", "rpc", rpc, "remote", remote, "error", err)
 			return
 		}
+		logger.Debug("Handled RPC", "rpc", rpc, "remote", remote, "latency", time.Since(start))
 	}
 }
 
@@ -825,7 +1721,6 @@ func trimSlice(vn []*Vnode) []*Vnode {
 		return vn
 	}
 
-	//fmt.Println(len(vn))
 	//return vn
 
 	// Find a non-nil index
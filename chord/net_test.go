@@ -1,7 +1,10 @@
 package chord
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 )
@@ -83,10 +86,169 @@ func TestTCPLeave(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 
 	// Verify r2 ring is still in tact
-	for _, vn := range r2.vnodes {
-		if vn.successors[0].Host != r2.config.Hostname {
-			t.Fatalf("bad successor! Got:%s:%s", vn.successors[0].Host,
-				vn.successors[0])
+	for _, vn := range r2.Vnodes {
+		if succ := vn.successor(); succ.Host != r2.config.Hostname {
+			t.Fatalf("bad successor! Got:%s:%s", succ.Host, succ)
 		}
 	}
 }
+
+func TestTCPTransfer(t *testing.T) {
+	listen := "localhost:10048"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	local := r.Vnodes[0]
+	vn := &local.Vnode
+	key := []byte("foo")
+	items := []*Item{{Key: key, Hash: r.hashKey(key), Value: []byte("bar"), Clock: VectorClock{"test": 1}}}
+	if err := trans.Transfer(vn, items); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	item, err := local.Get(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("bar")) {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+	missing := &Vnode{Id: []byte{1}, Host: listen}
+	if err := trans.Transfer(missing, items); err == nil {
+		t.Fatalf("expected err!")
+	}
+}
+
+func TestTCPErrors(t *testing.T) {
+	listen := "localhost:10049"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Errors that callers check for keep their identity over TCP
+	key, vn := keyOwnedBy(t, r, listen)
+	local := ringVnode(vn, r)
+	if _, err := trans.Get(vn, key); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := trans.Append(vn, key, []byte("x")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := trans.Increment(vn, key, 1); err != ErrNotCounter {
+		t.Fatalf("expected not a counter, got %v", err)
+	}
+	if err := local.TxnPrepare("test/1", vn, []*Item{{Key: key, Value: []byte("txn")}}, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	put := &Item{Key: key, Value: []byte("put"), Clock: VectorClock{"other": 1}}
+	if err := trans.Put(vn, put); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	errs, err := trans.MultiPut([]*Vnode{vn}, []*Item{put})
+	if err != nil || errs[0] != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v %v", errs, err)
+	}
+
+	// Writes are refused once the vnode left
+	local.left.Store(true)
+	item := &Item{Key: []byte("bar"), Value: []byte("baz"), Clock: VectorClock{"test": 1}}
+	if err := trans.Put(vn, item); err != errVnodeLeft {
+		t.Fatalf("expected the vnode to have left, got %v", err)
+	}
+	if err := trans.Transfer(vn, []*Item{item}); err != errVnodeLeft {
+		t.Fatalf("expected the vnode to have left, got %v", err)
+	}
+	if _, err := trans.Append(vn, []byte("bar"), []byte("x")); err != errVnodeLeft {
+		t.Fatalf("expected the vnode to have left, got %v", err)
+	}
+}
+
+// Answers every request on a listener with the given responses, in turn
+func serveResponses(sock net.Listener, resps ...interface{}) {
+	conn, err := sock.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+	for _, resp := range resps {
+		header := tcpHeader{}
+		if err := dec.Decode(&header); err != nil {
+			return
+		}
+		var body interface{}
+		switch header.ReqType {
+		case tcpMultiGetReq:
+			body = &tcpBodyMultiKey{}
+		case tcpMultiPutReq:
+			body = &tcpBodyMultiItem{}
+		}
+		if err := dec.Decode(body); err != nil {
+			return
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func TestTCPBadMultiResponse(t *testing.T) {
+	listen := "localhost:10050"
+	sock, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer sock.Close()
+	go serveResponses(sock,
+		&tcpBodyMultiItemError{Found: []bool{true, true}, Items: []*Item{{Key: []byte("foo")}}},
+		&tcpBodyMultiItemError{Found: []bool{false}},
+		&tcpBodyMultiError{Failed: []bool{true, true}, Errs: []error{&tcpError{Msg: "failed"}}},
+		&tcpBodyMultiError{Failed: []bool{false}},
+	)
+
+	trans, err := InitTCPTransport("localhost:10051", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	// Responses missing entries fail instead of panicking
+	vn := &Vnode{Id: []byte{1}, Host: listen}
+	targets := []*Vnode{vn, vn}
+	keys := [][]byte{[]byte("foo"), []byte("bar")}
+	if _, err := trans.MultiGet(targets, keys); err == nil {
+		t.Fatalf("expected err!")
+	}
+	if _, err := trans.MultiGet(targets, keys); err == nil {
+		t.Fatalf("expected err!")
+	}
+	items := []*Item{{Key: []byte("foo")}, {Key: []byte("bar")}}
+	if _, err := trans.MultiPut(targets, items); err == nil {
+		t.Fatalf("expected err!")
+	}
+	if _, err := trans.MultiPut(targets, items); err == nil {
+		t.Fatalf("expected err!")
+	}
+}
@@ -2,27 +2,51 @@ package chord
 
 import (
 	"bytes"
-	"log"
 	"sort"
 )
 
-func (r *Ring) init(conf *Config, trans Transport) {
+func (r *Ring) init(conf *Config, trans Transport) error {
 	// Set our variables
 	r.config = conf
+	r.clockActor = newClockActor(conf.Hostname)
 	r.Vnodes = make([]*LocalVnode, conf.NumVnodes)
-	r.transport = InitLocalTransport(trans)
+	r.transport = newLocalTransport(trans, r.logger())
 	r.delegateCh = make(chan func(), 32)
 
+	// Share our logger with a transport that logs, such as TCPTransport
+	if setter, ok := trans.(loggerSetter); ok && conf.Logger != nil {
+		setter.SetLogger(conf.Logger)
+	}
+
+	// Open the hints before any vnode can stabilize
+	hints, err := openHints(r)
+	if err != nil {
+		return err
+	}
+	r.hints = hints
+
+	// Reload the transactions we coordinate
+	txns, err := openTxnLog(r)
+	if err != nil {
+		r.hints.close()
+		return err
+	}
+	r.txns = txns
+
 	// Initializes the vnodes
 	for i := 0; i < conf.NumVnodes; i++ {
 		vn := &LocalVnode{}
 		r.Vnodes[i] = vn
 		vn.Ring = r
-		vn.Init(i)
+		if err := vn.Init(i); err != nil {
+			r.closeStores()
+			return err
+		}
 	}
 
 	// Sort the vnodes
 	sort.Sort(r)
+	return nil
 }
 
 // Len is the number of vnodes
@@ -64,12 +88,23 @@ func (r *Ring) schedule() {
 
 // Wait for all the vnodes to shutdown
 func (r *Ring) stopVnodes() {
-	r.shutdown = make(chan bool, r.config.NumVnodes)
+	shutdown := make(chan bool, r.config.NumVnodes)
+	r.stopLock.Lock()
+	r.shutdown = shutdown
+	r.stopLock.Unlock()
 	for i := 0; i < r.config.NumVnodes; i++ {
-		<-r.shutdown
+		<-shutdown
 	}
 }
 
+// Returns the channel the vnodes signal once they stop,
+// or nil if they should keep running
+func (r *Ring) stopping() chan bool {
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+	return r.shutdown
+}
+
 // Stops the delegate handler
 func (r *Ring) stopDelegate() {
 	if r.config.Delegate != nil {
@@ -79,14 +114,36 @@ func (r *Ring) stopDelegate() {
 	}
 }
 
+// Closes the stores of each vnode, the hints and the transaction log
+func (r *Ring) closeStores() error {
+	var err error
+	for _, vn := range r.Vnodes {
+		if vn != nil && vn.Store != nil {
+			err = mergeErrors(err, vn.Store.Close())
+		}
+		if vn != nil && vn.txnStore != nil {
+			err = mergeErrors(err, vn.txnStore.Close())
+		}
+	}
+	if r.hints != nil {
+		err = mergeErrors(err, r.hints.close())
+	}
+	if r.txns != nil {
+		err = mergeErrors(err, r.txns.close())
+	}
+	return err
+}
+
 // Initializes the vnodes with their local successors
 func (r *Ring) setLocalSuccessors() {
 	numV := len(r.Vnodes)
 	numSuc := min(r.config.NumSuccessors, numV-1)
 	for idx, vnode := range r.Vnodes {
+		vnode.lock.Lock()
 		for i := 0; i < numSuc; i++ {
 			vnode.Successors[i] = &r.Vnodes[(idx+i+1)%numV].Vnode
 		}
+		vnode.lock.Unlock()
 	}
 }
 
@@ -95,9 +152,11 @@ func (r *Ring) setLocalPredecessor() {
 	numV := len(r.Vnodes)
 	numPred := min(1, numV-1)
 	for idx, vnode := range r.Vnodes {
+		vnode.lock.Lock()
 		for i := 0; i < numPred ; i++ {
 			vnode.Predecessor = &r.Vnodes[(idx+i-1)%numV].Vnode
 		}
+		vnode.lock.Unlock()
 	}
 }
 
@@ -130,11 +189,16 @@ func (r *Ring) delegateHandler() {
 	}
 }
 
+// Returns the logger of the ring
+func (r *Ring) logger() Logger {
+	return configLogger(r.config)
+}
+
 // Called to safely call a function on the delegate
 func (r *Ring) safeInvoke(f func()) {
 	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Caught a panic invoking a delegate function! Got: %s", r)
+		if p := recover(); p != nil {
+			r.logger().Error("Caught a panic invoking a delegate function", "panic", p)
 		}
 	}()
 	f()
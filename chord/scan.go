@@ -0,0 +1,140 @@
+package chord
+
+import (
+	"bytes"
+	"time"
+)
+
+// Scan returns up to limit items with a key hash in (start, end],
+// ordered around the ring from start. Equal start and end cover the
+// whole ring, and a limit <= 0 returns everything. Each part of the
+// range is read from the first replica of its owner that answers,
+// so recent writes may be missing. Only live values are returned.
+func (r *Ring) Scan(start, end []byte, limit int) ([]*Item, error) {
+	var out []*Item
+	now := time.Now()
+	pos := start
+	for limit <= 0 || len(out) < limit {
+		// Find the owner of the next part of the range
+		replicas, err := r.replicasForHash(nextHash(pos))
+		if err != nil {
+			return out, &UnreachableError{Err: err}
+		}
+		segEnd := end
+		if inInterval(pos, end, replicas[0].Id) {
+			segEnd = replicas[0].Id
+		}
+
+		want := 0
+		if limit > 0 {
+			want = limit - len(out)
+		}
+		items, err := r.scanReplicas(replicas, pos, segEnd, want)
+		if err != nil {
+			return out, err
+		}
+		for _, item := range items {
+			if live := item.Live(now); live != nil {
+				out = append(out, live)
+			}
+		}
+
+		// A full page may have left items behind in the same part
+		if want > 0 && len(items) == want {
+			pos = items[len(items)-1].Hash
+		} else {
+			pos = segEnd
+		}
+		if bytes.Equal(pos, end) {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Scans (start, end] on the first replica that answers
+func (r *Ring) scanReplicas(replicas []*Vnode, start, end []byte, limit int) ([]*Item, error) {
+	var err error
+	for _, vn := range replicas {
+		items, scanErr := r.transport.Scan(vn, start, end, limit)
+		if scanErr == nil {
+			return items, nil
+		}
+		err = mergeErrors(err, scanErr)
+	}
+	return nil, &UnreachableError{Vnode: replicas[0], Err: err}
+}
+
+// Returns the hash following a hash, wrapping around the ring
+func nextHash(hash []byte) []byte {
+	next := make([]byte, len(hash))
+	copy(next, hash)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// Iterator walks the live items of a ring in hash order, fetching
+// them a page at a time with Scan
+type Iterator struct {
+	ring     *Ring
+	start    []byte
+	pos      []byte
+	pageSize int
+	page     []*Item
+	item     *Item
+	done     bool
+	err      error
+}
+
+// Iterate returns an Iterator over the whole ring, starting
+// after the given ID and fetching pageSize items at a time
+func (r *Ring) Iterate(start []byte, pageSize int) *Iterator {
+	if pageSize <= 0 {
+		pageSize = transferBatchSize
+	}
+	return &Iterator{ring: r, start: start, pos: start, pageSize: pageSize}
+}
+
+// Next advances to the next item, returning false once the
+// walk is complete or has failed
+func (it *Iterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.item, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Item returns the current item
+func (it *Iterator) Item() *Item {
+	return it.item
+}
+
+// Err returns the error that stopped the walk, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Fetches the next page
+func (it *Iterator) fetch() {
+	it.page, it.err = it.ring.Scan(it.pos, it.start, it.pageSize)
+	if it.err != nil {
+		return
+	}
+	if len(it.page) < it.pageSize {
+		it.done = true
+		return
+	}
+	it.pos = it.page[len(it.page)-1].Hash
+	if bytes.Equal(it.pos, it.start) {
+		it.done = true
+	}
+}
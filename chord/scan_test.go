@@ -0,0 +1,96 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNextHash(t *testing.T) {
+	if h := nextHash([]byte{0, 0xff}); !bytes.Equal(h, []byte{1, 0}) {
+		t.Fatalf("bad hash: %x", h)
+	}
+	if h := nextHash([]byte{0xff, 0xff}); !bytes.Equal(h, []byte{0, 0}) {
+		t.Fatalf("bad hash: %x", h)
+	}
+}
+
+func TestRingScan(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key, WithWriteQuorum(2)); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	if err := r.Delete([]byte("key0"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Every live key once, in ring order from the start
+	start := r.Vnodes[0].Id
+	items, err := r2.Scan(start, start, 0)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(items) != 49 {
+		t.Fatalf("expected 49 items, got %d", len(items))
+	}
+	seen := make(map[string]bool)
+	for idx, item := range items {
+		if seen[string(item.Key)] || string(item.Key) == "key0" {
+			t.Fatalf("unexpected key %s", item.Key)
+		}
+		seen[string(item.Key)] = true
+		if idx > 0 && !betweenRightIncl(items[idx-1].Hash, start, item.Hash) {
+			t.Fatalf("items out of order at %d", idx)
+		}
+	}
+
+	// Limits and partial ranges
+	if items, err := r2.Scan(start, start, 10); err != nil || len(items) != 10 {
+		t.Fatalf("expected 10 items, got %d. %v", len(items), err)
+	}
+	end := items[19].Hash
+	part, err := r.Scan(start, end, 0)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(part) != 20 || !bytes.Equal(part[19].Key, items[19].Key) {
+		t.Fatalf("expected the first 20 items, got %d", len(part))
+	}
+
+	// Walk the ring a page at a time
+	var walked int
+	it := r.Iterate(start, 7)
+	for it.Next() {
+		if !bytes.Equal(it.Item().Key, items[walked].Key) {
+			t.Fatalf("bad key at %d: %s", walked, it.Item().Key)
+		}
+		walked++
+	}
+	if it.Err() != nil {
+		t.Fatalf("unexpected err. %s", it.Err())
+	}
+	if walked != 49 {
+		t.Fatalf("expected 49 items, got %d", walked)
+	}
+}
@@ -0,0 +1,127 @@
+package chord
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Format of the archives written by Snapshot
+const snapshotFormat = 1
+
+// Leads a snapshot archive, followed by one vnodeSnapshot per vnode
+type snapshotHeader struct {
+	Format   int       // Format of the archive
+	Hostname string    // Host the snapshot was taken on
+	Taken    time.Time // Time the snapshot was taken
+	Vnodes   int       // Number of vnodes that follow
+}
+
+// The items of a vnode, and the hash range (Start, Id] it owned
+type vnodeSnapshot struct {
+	Id    []byte
+	Start []byte // Id of the predecessor, nil if not known
+	Items []*Item
+}
+
+// Snapshot writes a point-in-time archive of the stores of our local
+// vnodes to w, without stopping them. Writes to the vnodes are held
+// while their items are collected, and every version, tombstone and
+// expiry is kept, so the archive can be loaded with Restore.
+func (r *Ring) Snapshot(w io.Writer) error {
+	snaps := r.collectSnapshot()
+	enc := gob.NewEncoder(w)
+	header := &snapshotHeader{
+		Format:   snapshotFormat,
+		Hostname: r.config.Hostname,
+		Taken:    time.Now(),
+		Vnodes:   len(snaps),
+	}
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("Failed to write snapshot! Got %s", err)
+	}
+	for _, snap := range snaps {
+		if err := enc.Encode(snap); err != nil {
+			return fmt.Errorf("Failed to write snapshot! Got %s", err)
+		}
+	}
+	return nil
+}
+
+// Collects the items of every local vnode, holding the write lock of
+// all of them so they are taken at the same point. Every change to a
+// store holds it, including the deletes made by a handoff or a sweep.
+func (r *Ring) collectSnapshot() []*vnodeSnapshot {
+	for _, vn := range r.Vnodes {
+		vn.writeLock.Lock()
+	}
+	defer func() {
+		for _, vn := range r.Vnodes {
+			vn.writeLock.Unlock()
+		}
+	}()
+
+	snaps := make([]*vnodeSnapshot, len(r.Vnodes))
+	for idx, vn := range r.Vnodes {
+		snaps[idx] = &vnodeSnapshot{Id: vn.Id, Items: vn.collectRange(vn.Id, vn.Id, 0)}
+		if pred := vn.predecessor(); pred != nil {
+			snaps[idx].Start = pred.Id
+		}
+	}
+	return snaps
+}
+
+// Restore loads an archive written by Snapshot. The ring may have
+// changed since the snapshot was taken, so each item is sent to the
+// current replicas of its key, one vnode of the archive at a time.
+// Items are merged with the versions already stored, so restoring
+// does not undo writes made since the snapshot.
+func (r *Ring) Restore(rd io.Reader) error {
+	dec := gob.NewDecoder(rd)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("Failed to read snapshot! Got %s", err)
+	}
+	if header.Format != snapshotFormat {
+		return fmt.Errorf("Unsupported snapshot format! Got %d", header.Format)
+	}
+
+	var err error
+	var failed int
+	for i := 0; i < header.Vnodes; i++ {
+		var snap vnodeSnapshot
+		if decErr := dec.Decode(&snap); decErr != nil {
+			return mergeErrors(err, fmt.Errorf("Failed to read snapshot! Got %s", decErr))
+		}
+		for _, itemErr := range r.restoreItems(snap.Items) {
+			if itemErr != nil {
+				err = mergeErrors(err, itemErr)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to restore %d keys! Got %s", failed, err)
+	}
+	return nil
+}
+
+// Sends items to every replica of their keys, returning
+// the error of each item that no replica stored
+func (r *Ring) restoreItems(items []*Item) []error {
+	keys := make([][]byte, len(items))
+	for idx, item := range items {
+		keys[idx] = item.Key
+	}
+	errs := make([]error, len(items))
+	replicas := r.batchReplicas(keys, errs)
+
+	acked, failed := r.putBatches(items, replicas)
+	for idx := range items {
+		if errs[idx] == nil && len(acked[idx]) == 0 {
+			errs[idx] = batchQuorumError(1, replicas[idx], nil, failed[idx])
+		}
+	}
+	return errs
+}
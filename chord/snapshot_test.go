@@ -0,0 +1,180 @@
+package chord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRingSnapshotRestore(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	r.Delete([]byte("key0"))
+
+	var buf bytes.Buffer
+	if err := r.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Writes after the snapshot are not undone by restoring it
+	if err := r.Put([]byte("key1"), []byte("newer")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := r.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if val, err := r.Get([]byte("key1")); err != nil || !bytes.Equal(val, []byte("newer")) {
+		t.Fatalf("unexpected result. %s %v", val, err)
+	}
+
+	// Load it into an empty ring
+	r2, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r2.Shutdown()
+	if err := r2.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	for i := 1; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		val, err := r2.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", key, err)
+		}
+		if !bytes.Equal(val, key) {
+			t.Fatalf("bad value: %s", val)
+		}
+	}
+	if _, err := r2.Get([]byte("key0")); err != ErrKeyNotFound {
+		t.Fatalf("expected the delete to be restored, got %v", err)
+	}
+}
+
+func TestRingSnapshotHoldsDeletes(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("foo")
+	if err := r.Put(key, key, WithTTL(time.Millisecond)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	replicas, err := r.replicas(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	vn := ringVnode(replicas[0], r)
+	item, err := vn.Store.Get(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	<-time.After(10 * time.Millisecond)
+
+	// Hold the writes as a snapshot does
+	for _, local := range r.Vnodes {
+		local.writeLock.Lock()
+	}
+	done := make(chan bool, 2)
+	go func() {
+		vn.deleteSent(item)
+		done <- true
+	}()
+	go func() {
+		vn.sweep(time.Now())
+		done <- true
+	}()
+
+	// Handoff and sweep deletes wait for the snapshot
+	select {
+	case <-done:
+		t.Fatalf("expected the deletes to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, local := range r.Vnodes {
+		local.writeLock.Unlock()
+	}
+	<-done
+	<-done
+}
+
+func TestRingRestoreRedistributes(t *testing.T) {
+	// Snapshot a ring of a single host
+	r, err := Create(kvConf("old"), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := r.Put(key, key); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := r.Snapshot(&buf); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Shutdown()
+
+	// Restore it into a ring of two hosts
+	ml := InitMLTransport()
+	r1, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r1.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	if err := r2.Restore(&buf); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Each key is on the vnode now owning it, and its replica
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		replicas, err := r1.replicas(key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		for _, vn := range replicas {
+			if _, err := ml.Get(vn, key); err != nil {
+				t.Fatalf("expected %s on %s. %s", key, vn.String(), err)
+			}
+		}
+	}
+}
+
+func TestRingRestoreBadFormat(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&snapshotHeader{Format: snapshotFormat + 1})
+	if err := r.Restore(&buf); err == nil {
+		t.Fatalf("expected err")
+	}
+}
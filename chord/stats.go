@@ -0,0 +1,42 @@
+package chord
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the counters kept by a ring
+type Stats struct {
+	ReadRepairs        uint64 // Stale or missing replicas updated after a Get
+	ReadRepairFailures uint64 // Read repairs that could not be delivered
+	AntiEntropyRounds  uint64 // Anti-entropy rounds run by local vnodes
+	AntiEntropyKeys    uint64 // Keys exchanged with replicas by anti-entropy
+	HintsStored        uint64 // Writes held for unreachable replicas
+	HintsDelivered     uint64 // Held writes delivered once the replica was back
+	HintsDropped       uint64 // Writes not held because the hint store was full
+	PendingHints       uint64 // Writes currently held
+}
+
+// Counters updated while the ring is running
+type ringStats struct {
+	readRepairs        atomic.Uint64
+	readRepairFailures atomic.Uint64
+	antiEntropyRounds  atomic.Uint64
+	antiEntropyKeys    atomic.Uint64
+	hintsStored        atomic.Uint64
+	hintsDelivered     atomic.Uint64
+	hintsDropped       atomic.Uint64
+}
+
+// Stats returns the current value of the ring's counters
+func (r *Ring) Stats() Stats {
+	return Stats{
+		ReadRepairs:        r.stats.readRepairs.Load(),
+		ReadRepairFailures: r.stats.readRepairFailures.Load(),
+		AntiEntropyRounds:  r.stats.antiEntropyRounds.Load(),
+		AntiEntropyKeys:    r.stats.antiEntropyKeys.Load(),
+		HintsStored:        r.stats.hintsStored.Load(),
+		HintsDelivered:     r.stats.hintsDelivered.Load(),
+		HintsDropped:       r.stats.hintsDropped.Load(),
+		PendingHints:       uint64(r.hints.pending()),
+	}
+}
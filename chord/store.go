@@ -0,0 +1,95 @@
+package chord
+
+import (
+	"sync"
+	"time"
+)
+
+// Item is a key/value pair stored on a vnode
+type Item struct {
+	Key      []byte
+	Hash     []byte // Hash of the key, places the item on the ring
+	Value    []byte
+	Clock    VectorClock // Version of the value
+	Siblings []*Version  // Versions written concurrently with Value
+	Deleted  time.Time   // Time of the delete, if Value is a tombstone
+	Expires  time.Time   // Time Value expires, if written with a TTL
+	Version  uint64      // Number of writes made on the owner of the key
+}
+
+// Store is the storage engine holding the items of a local vnode.
+// Implementations must be safe for concurrent use. Items handed to
+// and returned from a Store must not be modified afterwards.
+type Store interface {
+	// Get returns the item stored under a key, or ErrKeyNotFound
+	Get(key []byte) (*Item, error)
+
+	// Put stores an item, replacing any item with the same key
+	Put(item *Item) error
+
+	// Delete removes the item stored under a key, if any
+	Delete(key []byte) error
+
+	// Range invokes fn on each item with a hash in (start, end], in
+	// no particular order, stopping early if fn returns false.
+	// Equal start and end cover the whole ring. fn must not
+	// modify the store.
+	Range(start, end []byte, fn func(*Item) bool) error
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// MemoryStore is a Store that keeps all items in memory.
+// It is used when Config.StoreFunc is not provided.
+type MemoryStore struct {
+	lock  sync.RWMutex
+	items map[string]*Item
+}
+
+// Creates a new, empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]*Item)}
+}
+
+func (m *MemoryStore) Get(key []byte) (*Item, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	item, ok := m.items[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return item, nil
+}
+
+func (m *MemoryStore) Put(item *Item) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.items[string(item.Key)] = item
+	return nil
+}
+
+func (m *MemoryStore) Delete(key []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.items, string(key))
+	return nil
+}
+
+func (m *MemoryStore) Range(start, end []byte, fn func(*Item) bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, item := range m.items {
+		if !inInterval(start, end, item.Hash) {
+			continue
+		}
+		if !fn(item) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,97 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMemoryStoreGetPut(t *testing.T) {
+	m := NewMemoryStore()
+	if _, err := m.Get([]byte("foo")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	item := &Item{Key: []byte("foo"), Hash: []byte{10}, Value: []byte("bar")}
+	if err := m.Put(item); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	out, err := m.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(out.Value, []byte("bar")) {
+		t.Fatalf("bad value: %s", out.Value)
+	}
+
+	if err := m.Delete([]byte("foo")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := m.Get([]byte("foo")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestMemoryStoreRange(t *testing.T) {
+	m := NewMemoryStore()
+	for i := 0; i < 10; i++ {
+		m.Put(&Item{Key: []byte(fmt.Sprintf("key%d", i)), Hash: []byte{byte(i * 10)}})
+	}
+
+	// Right inclusive interval
+	var n int
+	m.Range([]byte{20}, []byte{50}, func(item *Item) bool {
+		n++
+		return true
+	})
+	if n != 3 {
+		t.Fatalf("expected 3 items, got %d", n)
+	}
+
+	// Wrapped interval
+	n = 0
+	m.Range([]byte{70}, []byte{10}, func(item *Item) bool {
+		n++
+		return true
+	})
+	if n != 4 {
+		t.Fatalf("expected 4 items, got %d", n)
+	}
+
+	// Stop early
+	n = 0
+	m.Range([]byte{0}, []byte{0}, func(item *Item) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatalf("expected to stop after 2 items, got %d", n)
+	}
+}
+
+func TestConfigStoreFunc(t *testing.T) {
+	var opened int
+	conf := fastConf()
+	conf.StoreFunc = func(vn *Vnode) (Store, error) {
+		opened++
+		return NewMemoryStore(), nil
+	}
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	if opened != conf.NumVnodes {
+		t.Fatalf("expected %d stores, got %d", conf.NumVnodes, opened)
+	}
+}
+
+func TestConfigStoreFuncError(t *testing.T) {
+	conf := fastConf()
+	conf.StoreFunc = func(vn *Vnode) (Store, error) {
+		return nil, fmt.Errorf("no disk")
+	}
+	if _, err := Create(conf, nil); err == nil {
+		t.Fatalf("expected err!")
+	}
+}
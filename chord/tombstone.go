@@ -0,0 +1,125 @@
+package chord
+
+import (
+	"time"
+)
+
+const (
+	// Time tombstones are kept before being purged, if not configured
+	defaultTombstoneGrace = 24 * time.Hour
+
+	// Time between sweeps of expired items, if not configured
+	defaultSweepInterval = time.Minute
+)
+
+// Checks if the version records a delete
+func (v *Version) tombstone() bool {
+	return !v.Deleted.IsZero()
+}
+
+// Checks if the version holds a value that is readable at a time
+func (v *Version) live(now time.Time) bool {
+	return !v.tombstone() && (v.Expires.IsZero() || now.Before(v.Expires))
+}
+
+// Returns the versions holding readable values
+func liveVersions(vs []*Version, now time.Time) []*Version {
+	var out []*Version
+	for _, v := range vs {
+		if v.live(now) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Live returns a copy of the item holding only the values readable at
+// a time, or nil if it has none, such as when it is a tombstone
+func (i *Item) Live(now time.Time) *Item {
+	live := liveVersions(i.versions(), now)
+	if len(live) == 0 {
+		return nil
+	}
+	return i.withVersions(live)
+}
+
+// Returns a time as nanoseconds, or 0 if it is not set
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Returns the configured tombstone grace period
+func tombstoneGrace(conf *Config) time.Duration {
+	if conf.TombstoneGrace <= 0 {
+		return defaultTombstoneGrace
+	}
+	return conf.TombstoneGrace
+}
+
+/*
+Turns the expired values of an item into tombstones, and drops the
+tombstones older than the grace period. An expired value is kept as a
+tombstone so that an older copy held by another replica cannot come
+back, and the grace period gives the tombstone time to reach every
+replica before it is purged. Returns nil if nothing remains, and
+whether the item changed.
+*/
+func expireItem(item *Item, now time.Time, grace time.Duration) (*Item, bool) {
+	var out []*Version
+	changed := false
+	for _, v := range item.versions() {
+		if !v.tombstone() && !v.Expires.IsZero() && !now.Before(v.Expires) {
+			v = &Version{Clock: v.Clock, Deleted: v.Expires}
+			changed = true
+		}
+		if v.tombstone() && now.Sub(v.Deleted) >= grace {
+			changed = true
+			continue
+		}
+		out = append(out, v)
+	}
+	if len(out) == 0 {
+		return nil, true
+	}
+	if !changed {
+		return item, false
+	}
+	return item.withVersions(out), true
+}
+
+// Sweeps the store if a sweep is due
+func (vn *LocalVnode) maybeSweep() {
+	interval := vn.Ring.config.SweepInterval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	if time.Since(vn.lastSweep) < interval {
+		return
+	}
+	vn.lastSweep = time.Now()
+	vn.sweep(vn.lastSweep)
+}
+
+// Expires the values and purges the tombstones in our store
+func (vn *LocalVnode) sweep(now time.Time) {
+	grace := tombstoneGrace(vn.Ring.config)
+	for _, item := range vn.collectRange(vn.Id, vn.Id, 0) {
+		if _, changed := expireItem(item, now, grace); !changed {
+			continue
+		}
+
+		// Apply to the current item, which may have been written since
+		vn.writeLock.Lock()
+		if cur, err := vn.Store.Get(item.Key); err == nil {
+			if swept, changed := expireItem(cur, now, grace); swept == nil {
+				vn.Store.Delete(cur.Key)
+			} else if changed && vn.Store.Put(swept) == nil {
+				vn.notifyWatchers(swept.Key, swept)
+			}
+		}
+		vn.writeLock.Unlock()
+	}
+}
@@ -0,0 +1,150 @@
+package chord
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExpireItem(t *testing.T) {
+	now := time.Now()
+	grace := time.Minute
+
+	// Live values are kept as is
+	item := &Item{Key: []byte("foo"), Value: []byte("bar"), Clock: VectorClock{"a": 1},
+		Expires: now.Add(time.Second)}
+	if out, changed := expireItem(item, now, grace); changed || out != item {
+		t.Fatalf("expected live item to be kept")
+	}
+
+	// Expired values become tombstones
+	item.Expires = now.Add(-time.Second)
+	out, changed := expireItem(item, now, grace)
+	if !changed || out == nil || !out.Deleted.Equal(item.Expires) || out.Value != nil {
+		t.Fatalf("expected a tombstone, got %+v", out)
+	}
+	if !out.Clock.Equal(item.Clock) {
+		t.Fatalf("expected the clock to be kept")
+	}
+
+	// Old tombstones are purged
+	if out, changed := expireItem(out, now.Add(grace), grace); !changed || out != nil {
+		t.Fatalf("expected the tombstone to be purged, got %+v", out)
+	}
+
+	// A tombstone is preferred over a copy of the same write
+	merged := mergeItems(item, &Item{Key: item.Key, Clock: item.Clock, Deleted: now})
+	if len(merged.Siblings) != 0 || merged.Deleted.IsZero() {
+		t.Fatalf("expected the tombstone to win, got %+v", merged)
+	}
+}
+
+func TestItemLive(t *testing.T) {
+	now := time.Now()
+
+	// Tombstones and expired values are not readable
+	if (&Item{Key: []byte("foo"), Deleted: now}).Live(now) != nil {
+		t.Fatalf("expected no live value for a tombstone")
+	}
+	if (&Item{Key: []byte("foo"), Value: []byte("bar"), Expires: now}).Live(now) != nil {
+		t.Fatalf("expected no live value once expired")
+	}
+
+	// A live sibling becomes the value
+	item := &Item{Key: []byte("foo"), Deleted: now, Clock: VectorClock{"a": 1},
+		Siblings: []*Version{{Value: []byte("bar"), Clock: VectorClock{"b": 1}}}}
+	live := item.Live(now)
+	if live == nil || !bytes.Equal(live.Value, []byte("bar")) || len(live.Siblings) != 0 {
+		t.Fatalf("bad live item: %+v", live)
+	}
+}
+
+func TestRingDeleteNoResurrect(t *testing.T) {
+	// Create a multi transport
+	ml := InitMLTransport()
+
+	// Create two rings
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	key := []byte("foo")
+	if err := r.Put(key, []byte("bar"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	replicas, err := r.replicas(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	old, err := ml.Get(replicas[1], key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	if err := r2.Delete(key, WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// A stale copy sent back by a replica does not resurrect the key
+	if err := ml.Put(replicas[0], old); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := r.Get(key, WithReadQuorum(2)); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// A write after the delete is visible
+	if err := r.Put(key, []byte("baz"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	val, err := r2.Get(key, WithReadQuorum(2))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(val, []byte("baz")) {
+		t.Fatalf("bad value: %s", val)
+	}
+}
+
+func TestRingTTL(t *testing.T) {
+	conf := fastConf()
+	conf.SweepInterval = 10 * time.Millisecond
+	conf.TombstoneGrace = 50 * time.Millisecond
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("foo")
+	if err := r.Put(key, []byte("bar"), WithTTL(50*time.Millisecond)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := r.Get(key); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// The value reads as deleted once expired
+	<-time.After(60 * time.Millisecond)
+	if _, err := r.Get(key); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// The sweeper purges it once the grace period is over
+	<-time.After(200 * time.Millisecond)
+	for _, vn := range r.Vnodes {
+		if _, err := vn.Store.Get(key); err != ErrKeyNotFound {
+			t.Fatalf("expected %s to purge the key, got %v", vn.String(), err)
+		}
+	}
+}
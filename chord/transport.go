@@ -3,6 +3,7 @@ package chord
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
 // Wraps vnode and object
@@ -17,24 +18,30 @@ type localRPC struct {
 type LocalTransport struct {
 	host   string
 	remote Transport
+	logger Logger
 	lock   sync.RWMutex
 	local  map[string]*localRPC
 }
 
 // Creates a local transport to wrap a remote transport
 func InitLocalTransport(remote Transport) Transport {
+	return newLocalTransport(remote, noopLogger{})
+}
+
+// Creates a local transport logging to the given logger
+func newLocalTransport(remote Transport, logger Logger) *LocalTransport {
 	// Replace a nil transport with black hole
 	if remote == nil {
 		remote = &BlackholeTransport{}
 	}
 
 	local := make(map[string]*localRPC)
-	return &LocalTransport{remote: remote, local: local}
+	return &LocalTransport{remote: remote, logger: logger, local: local}
 }
 
 // Checks for a local vnode
 func (lt *LocalTransport) get(vn *Vnode) (VnodeRPC, bool) {
-	key := vn.String()
+	key := vn.ident()
 	lt.lock.RLock()
 	defer lt.lock.RUnlock()
 	w, ok := lt.local[key]
@@ -143,31 +150,290 @@ func (lt *LocalTransport) SkipSuccessor(target, self *Vnode) error {
 	return lt.remote.SkipSuccessor(target, self)
 }
 
-func (lt *LocalTransport) PutKey(vn *Vnode, value int) error  {
-	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+func (lt *LocalTransport) Get(vn *Vnode, key []byte) (*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Get(key)
+	}
+
+	// Pass onto remote
+	return lt.remote.Get(vn, key)
+}
+
+func (lt *LocalTransport) Put(vn *Vnode, item *Item) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Put(item)
+	}
+
+	// Pass onto remote
+	return lt.remote.Put(vn, item)
+}
+
+func (lt *LocalTransport) Delete(vn *Vnode, key []byte) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Delete(key)
+	}
+
+	// Pass onto remote
+	return lt.remote.Delete(vn, key)
+}
+
+func (lt *LocalTransport) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	// Look for them locally, they are all on the same host
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	if _, ok := lt.get(targets[0]); !ok {
+		// Pass onto remote
+		return lt.remote.MultiGet(targets, keys)
+	}
+	items := make([]*Item, len(keys))
+	for idx, vn := range targets {
+		obj, ok := lt.get(vn)
+		if !ok {
+			return nil, fmt.Errorf("Target VN not found! Target %s:%s", vn.Host, vn.String())
+		}
+		item, err := obj.Get(keys[idx])
+		if err != nil && err != ErrKeyNotFound {
+			return nil, err
+		}
+		items[idx] = item
+	}
+	return items, nil
+}
+
+func (lt *LocalTransport) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	// Look for them locally, they are all on the same host
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	if _, ok := lt.get(targets[0]); !ok {
+		// Pass onto remote
+		return lt.remote.MultiPut(targets, items)
+	}
+	errs := make([]error, len(items))
+	for idx, vn := range targets {
+		if obj, ok := lt.get(vn); ok {
+			errs[idx] = obj.Put(items[idx])
+		} else {
+			errs[idx] = fmt.Errorf("Target VN not found! Target %s:%s", vn.Host, vn.String())
+		}
+	}
+	return errs, nil
+}
+
+func (lt *LocalTransport) CompareAndSwap(vn *Vnode, item *Item, expected uint64, absent bool) (*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.CompareAndSwap(item, expected, absent)
+	}
+
+	// Pass onto remote
+	return lt.remote.CompareAndSwap(vn, item, expected, absent)
+}
+
+func (lt *LocalTransport) Increment(vn *Vnode, key []byte, delta int64) (*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Increment(key, delta)
+	}
+
+	// Pass onto remote
+	return lt.remote.Increment(vn, key, delta)
+}
+
+func (lt *LocalTransport) Append(vn *Vnode, key, data []byte) (*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Append(key, data)
+	}
+
+	// Pass onto remote
+	return lt.remote.Append(vn, key, data)
+}
+
+func (lt *LocalTransport) Watch(vn *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Watch(key, id, subscriber)
+	}
+
+	// Pass onto remote
+	return lt.remote.Watch(vn, key, id, subscriber)
+}
+
+func (lt *LocalTransport) Unwatch(vn *Vnode, key []byte, id string) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Unwatch(key, id)
+	}
+
+	// Pass onto remote
+	return lt.remote.Unwatch(vn, key, id)
+}
+
+func (lt *LocalTransport) WatchEvent(vn *Vnode, id string, ev *WatchEvent) (bool, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.WatchEvent(id, ev)
+	}
+
+	// Pass onto remote
+	return lt.remote.WatchEvent(vn, id, ev)
+}
+
+func (lt *LocalTransport) TxnPrepare(vn *Vnode, id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.TxnPrepare(id, coordinator, items, timeout)
+	}
+
+	// Pass onto remote
+	return lt.remote.TxnPrepare(vn, id, coordinator, items, timeout)
+}
+
+func (lt *LocalTransport) TxnCommit(vn *Vnode, id string) ([]*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.TxnCommit(id)
+	}
+
+	// Pass onto remote
+	return lt.remote.TxnCommit(vn, id)
+}
+
+func (lt *LocalTransport) TxnAbort(vn *Vnode, id string) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.TxnAbort(id)
+	}
+
+	// Pass onto remote
+	return lt.remote.TxnAbort(vn, id)
+}
+
+func (lt *LocalTransport) TxnStatus(vn *Vnode, id string) (TxnState, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.TxnStatus(id)
+	}
+
+	// Pass onto remote
+	return lt.remote.TxnStatus(vn, id)
 }
 
-func (lt *LocalTransport) GetKey(vn *Vnode) (int, error)  {
-	return 0, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+func (lt *LocalTransport) Scan(vn *Vnode, start, end []byte, limit int) ([]*Item, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Scan(start, end, limit)
+	}
+
+	// Pass onto remote
+	return lt.remote.Scan(vn, start, end, limit)
+}
+
+func (lt *LocalTransport) Transfer(vn *Vnode, items []*Item) error {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.Transfer(items)
+	}
+
+	// Pass onto remote
+	return lt.remote.Transfer(vn, items)
+}
+
+func (lt *LocalTransport) MerkleNodes(vn *Vnode, start, end []byte, nodes []int) ([][]byte, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.MerkleNodes(start, end, nodes)
+	}
+
+	// Pass onto remote
+	return lt.remote.MerkleNodes(vn, start, end, nodes)
+}
+
+func (lt *LocalTransport) KeyDigests(vn *Vnode, start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	// Look for it locally
+	obj, ok := lt.get(vn)
+
+	// If it exists locally, handle it
+	if ok {
+		return obj.KeyDigests(start, end, leaves)
+	}
+
+	// Pass onto remote
+	return lt.remote.KeyDigests(vn, start, end, leaves)
 }
 
 func (lt *LocalTransport) Register(v *Vnode, o VnodeRPC) {
 	// Register local instance
-	key := v.String()
+	key := v.ident()
 	lt.lock.Lock()
 	lt.host = v.Host
 	lt.local[key] = &localRPC{v, o}
 	lt.lock.Unlock()
+	lt.logger.Debug("Registered local vnode", v.logFields()...)
 
 	// Register with remote transport
 	lt.remote.Register(v, o)
 }
 
 func (lt *LocalTransport) Deregister(v *Vnode) {
-	key := v.String()
+	key := v.ident()
 	lt.lock.Lock()
 	delete(lt.local, key)
 	lt.lock.Unlock()
+	lt.logger.Debug("Deregistered local vnode", v.logFields()...)
 }
 
 // BlackholeTransport is used to provide an implemenation of the Transport that
@@ -206,10 +472,78 @@ func (*BlackholeTransport) SkipSuccessor(target, self *Vnode) error {
 func (*BlackholeTransport) Register(v *Vnode, o VnodeRPC) {
 }
 
-func (*BlackholeTransport) PutKey(vn *Vnode, value int) error  {
+func (*BlackholeTransport) Get(vn *Vnode, key []byte) (*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Put(vn *Vnode, item *Item) error {
+	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Delete(vn *Vnode, key []byte) error {
 	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
 }
 
-func (*BlackholeTransport) GetKey(vn *Vnode) (int, error)  {
-	return 0, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+func (*BlackholeTransport) MultiGet(targets []*Vnode, keys [][]byte) ([]*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", targets[0].String())
+}
+
+func (*BlackholeTransport) MultiPut(targets []*Vnode, items []*Item) ([]error, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", targets[0].String())
+}
+
+func (*BlackholeTransport) CompareAndSwap(vn *Vnode, item *Item, expected uint64, absent bool) (*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Increment(vn *Vnode, key []byte, delta int64) (*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Append(vn *Vnode, key, data []byte) (*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Watch(vn *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Unwatch(vn *Vnode, key []byte, id string) error {
+	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) WatchEvent(vn *Vnode, id string, ev *WatchEvent) (bool, error) {
+	return false, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) TxnPrepare(vn *Vnode, id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) TxnCommit(vn *Vnode, id string) ([]*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) TxnAbort(vn *Vnode, id string) error {
+	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) TxnStatus(vn *Vnode, id string) (TxnState, error) {
+	return TxnPreparing, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Scan(vn *Vnode, start, end []byte, limit int) ([]*Item, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) Transfer(vn *Vnode, items []*Item) error {
+	return fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) MerkleNodes(vn *Vnode, start, end []byte, nodes []int) ([][]byte, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
+}
+
+func (*BlackholeTransport) KeyDigests(vn *Vnode, start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	return nil, fmt.Errorf("Failed to connect! Blackhole: %s", vn.String())
 }
@@ -3,6 +3,7 @@ package chord
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 type MockVnodeRPC struct {
@@ -13,6 +14,7 @@ type MockVnodeRPC struct {
 	key       []byte
 	succ      []*Vnode
 	skip      *Vnode
+	stored    map[string]*Item
 }
 
 func (mv *MockVnodeRPC) GetPredecessor() (*Vnode, error) {
@@ -37,6 +39,96 @@ func (mv *MockVnodeRPC) SkipSuccessor(s *Vnode) error {
 	return nil
 }
 
+func (mv *MockVnodeRPC) Get(key []byte) (*Item, error) {
+	if item, ok := mv.stored[string(key)]; ok {
+		return item, mv.err
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (mv *MockVnodeRPC) Put(item *Item) error {
+	if mv.stored == nil {
+		mv.stored = make(map[string]*Item)
+	}
+	mv.stored[string(item.Key)] = item
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) Delete(key []byte) error {
+	delete(mv.stored, string(key))
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) CompareAndSwap(item *Item, expected uint64, absent bool) (*Item, error) {
+	old, ok := mv.stored[string(item.Key)]
+	if ok == absent || (ok && old.Version != expected) {
+		return nil, &CASError{Key: item.Key, Expected: expected, Exists: ok}
+	}
+	put := *item
+	put.Version = expected + 1
+	mv.Put(&put)
+	return &put, mv.err
+}
+
+func (mv *MockVnodeRPC) Increment(key []byte, delta int64) (*Item, error) {
+	return nil, mv.err
+}
+
+func (mv *MockVnodeRPC) Append(key, data []byte) (*Item, error) {
+	return nil, mv.err
+}
+
+func (mv *MockVnodeRPC) Watch(key []byte, id string, subscriber *Vnode) (*Item, error) {
+	return mv.stored[string(key)], mv.err
+}
+
+func (mv *MockVnodeRPC) Unwatch(key []byte, id string) error {
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) WatchEvent(id string, ev *WatchEvent) (bool, error) {
+	return false, mv.err
+}
+
+func (mv *MockVnodeRPC) TxnPrepare(id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) TxnCommit(id string) ([]*Item, error) {
+	return nil, mv.err
+}
+
+func (mv *MockVnodeRPC) TxnAbort(id string) error {
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) TxnStatus(id string) (TxnState, error) {
+	return TxnAborted, mv.err
+}
+
+func (mv *MockVnodeRPC) Scan(start, end []byte, limit int) ([]*Item, error) {
+	var items []*Item
+	for _, item := range mv.stored {
+		items = append(items, item)
+	}
+	return items, mv.err
+}
+
+func (mv *MockVnodeRPC) Transfer(items []*Item) error {
+	for _, item := range items {
+		mv.Put(item)
+	}
+	return mv.err
+}
+
+func (mv *MockVnodeRPC) MerkleNodes(start, end []byte, nodes []int) ([][]byte, error) {
+	return make([][]byte, len(nodes)), mv.err
+}
+
+func (mv *MockVnodeRPC) KeyDigests(start, end []byte, leaves []int) ([]*KeyDigest, error) {
+	return nil, mv.err
+}
+
 func makeLocal() *LocalTransport {
 	return InitLocalTransport(nil).(*LocalTransport)
 }
@@ -62,7 +154,7 @@ func TestLocalList(t *testing.T) {
 		t.Fatalf("unexpected err. %s", err)
 	}
 	if len(list) != 1 || list[0] != vn {
-		t.Fatalf("local list failed", list)
+		t.Fatalf("local list failed: %v", list)
 	}
 }
 
@@ -240,6 +332,34 @@ func TestLocalDeregister(t *testing.T) {
 	}
 }
 
+func TestLocalCollidingIds(t *testing.T) {
+	l := makeLocal()
+	pred1 := &Vnode{Id: []byte{10}}
+	pred2 := &Vnode{Id: []byte{20}}
+	vn1 := &Vnode{Id: []byte{42, 1}, Host: "test"}
+	vn2 := &Vnode{Id: []byte{42, 2}, Host: "test"}
+	vn3 := &Vnode{Id: []byte{42, 1}, Host: "other"}
+	l.Register(vn1, &MockVnodeRPC{pred: pred1})
+	l.Register(vn2, &MockVnodeRPC{pred: pred2})
+
+	// IDs sharing a prefix route to their own vnode
+	if res, err := l.GetPredecessor(vn1); err != nil || res != pred1 {
+		t.Fatalf("got wrong predecessor")
+	}
+	if res, err := l.GetPredecessor(vn2); err != nil || res != pred2 {
+		t.Fatalf("got wrong predecessor")
+	}
+
+	// The same ID on another host is not local
+	if res, _ := l.Ping(vn3); res {
+		t.Fatalf("ping succeeded")
+	}
+	l.Deregister(vn1)
+	if res, err := l.Ping(vn2); !res || err != nil {
+		t.Fatalf("local ping failed")
+	}
+}
+
 func TestBHList(t *testing.T) {
 	bh := BlackholeTransport{}
 	res, err := bh.ListVnodes("test")
@@ -304,3 +424,12 @@ func TestBHSkipSucc(t *testing.T) {
 		t.Fatalf("expected fail")
 	}
 }
+
+func TestBHScan(t *testing.T) {
+	bh := BlackholeTransport{}
+	vn := &Vnode{Id: []byte{12}}
+	_, err := bh.Scan(vn, nil, nil, 0)
+	if err.Error()[:18] != "Failed to connect!" {
+		t.Fatalf("expected fail")
+	}
+}
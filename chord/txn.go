@@ -0,0 +1,647 @@
+package chord
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Directory under Config.DataDir holding the coordinator log
+	txnDir = "txns"
+
+	// Directory under Config.DataDir holding the transactions
+	// prepared on each vnode
+	preparedDir = "prepared"
+
+	// Time a transaction may hold its locks, if not configured
+	defaultTxnTimeout = 10 * time.Second
+
+	// Time an owner remembers a committed transaction, so that a
+	// coordinator that missed the acknowledgement can send it again
+	committedTxnTTL = 10 * time.Minute
+)
+
+// ErrKeyLocked is returned by a write to a key held by a transaction
+// that is prepared but not yet committed or aborted
+var ErrKeyLocked = errors.New("Key is locked by a transaction")
+
+// Returned by an owner asked to commit a transaction it does not hold,
+// as it lost it or already committed and forgot it
+var errTxnUnknown = errors.New("Transaction is not prepared")
+
+// TxnState is the outcome of a transaction, as decided by its coordinator
+type TxnState int
+
+const (
+	TxnPreparing TxnState = iota // Not decided yet
+	TxnCommitted
+	TxnAborted
+)
+
+// TxnOp is a write made by a transaction
+type TxnOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool // Delete the key instead of storing Value
+}
+
+// TxnAbortedError is returned by Txn when none of its writes were made
+type TxnAbortedError struct {
+	Id  string // Id of the transaction
+	Err error  // Cause of the abort
+}
+
+func (e *TxnAbortedError) Error() string {
+	return fmt.Sprintf("Transaction %s aborted! Got %s", e.Id, e.Err)
+}
+
+// TxnReplicationError is returned by Txn when the transaction was
+// committed, but some of its writes did not reach the write quorum.
+// The writes were made on the owners of their keys, so the
+// transaction must not be retried.
+type TxnReplicationError struct {
+	Id  string // Id of the transaction
+	Err error  // Errors of the writes that were not replicated
+}
+
+func (e *TxnReplicationError) Error() string {
+	return fmt.Sprintf("Transaction %s committed, but failed to replicate! Got %s", e.Id, e.Err)
+}
+
+// Returns the time a transaction may hold its locks
+func txnTimeout(conf *Config) time.Duration {
+	if conf.TxnTimeout <= 0 {
+		return defaultTxnTimeout
+	}
+	return conf.TxnTimeout
+}
+
+/*
+Txn makes a set of writes atomically: either all of them are made, or
+none are. The writes are sent to the vnodes owning their keys, which
+lock the keys against other transactions and hold the writes until the
+outcome is known (prepare). Once every owner is prepared, the commit
+is recorded in our coordinator log, and each owner makes its writes as
+a single update, replicating them like CompareAndSwap does (commit).
+If an owner cannot be prepared, or the transaction runs longer than
+Config.TxnTimeout, the writes are dropped and a *TxnAbortedError is
+returned, in which case the transaction may be retried. If it was
+committed but a write did not reach the write quorum, a
+*TxnReplicationError is returned instead, and it must not be retried.
+
+The coordinator log is durable if Config.DataDir is set. Transactions
+that were in doubt when we stopped are aborted if they were not yet
+committed, or finished if they were, once we are running again. Owners
+whose locks time out ask us for the outcome, and keep their locks until
+we answer, or abort once we could not be reached for another
+Config.TxnTimeout. An owner that lost the writes it held cannot commit
+them, which is logged as an error. The writes held by an owner are
+durable too if Config.DataDir is set, and other writes to the keys they
+lock fail with ErrKeyLocked until the outcome is known.
+*/
+func (r *Ring) Txn(ops []*TxnOp, opts ...Option) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	o := r.options(opts)
+	timeout := txnTimeout(r.config)
+
+	// Group the writes by the vnode owning their key
+	now := time.Now()
+	rec := &txnRecord{Id: r.txns.newId(), Deadline: now.Add(timeout)}
+	writes := make(map[string][]*Item)
+	for _, op := range ops {
+		replicas, err := r.replicas(op.Key)
+		if err != nil {
+			return err
+		}
+		owner := replicas[0]
+		item := &Item{Key: op.Key, Value: op.Value}
+		if op.Delete {
+			item.Value, item.Deleted = nil, now
+		} else if o.ttl > 0 {
+			item.Expires = now.Add(o.ttl)
+		}
+		if _, ok := writes[owner.ident()]; !ok {
+			rec.Participants = append(rec.Participants, owner)
+		}
+		writes[owner.ident()] = append(writes[owner.ident()], item)
+	}
+	rec.Done = make([]bool, len(rec.Participants))
+	if err := r.txns.begin(rec); err != nil {
+		return err
+	}
+	defer r.txns.release(rec.Id)
+
+	// Prepare every owner
+	self := &r.Vnodes[0].Vnode
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var err error
+	for _, vn := range rec.Participants {
+		wg.Add(1)
+		go func(vn *Vnode) {
+			defer wg.Done()
+			items := writes[vn.ident()]
+			if perr := r.transport.TxnPrepare(vn, rec.Id, self, items, timeout); perr != nil {
+				lock.Lock()
+				err = mergeErrors(err, perr)
+				lock.Unlock()
+			}
+		}(vn)
+	}
+	wg.Wait()
+
+	// Record the outcome, unless an owner timed out and it was aborted.
+	// Past the deadline, owners may give up on us, so we abort too.
+	if err == nil && time.Now().After(rec.Deadline) {
+		err = fmt.Errorf("Timed out after %s", timeout)
+	}
+	state := TxnAborted
+	if err == nil {
+		if state, err = r.txns.decide(rec.Id, TxnCommitted); err == nil && state != TxnCommitted {
+			err = fmt.Errorf("Timed out after %s", timeout)
+		}
+	} else if _, derr := r.txns.decide(rec.Id, TxnAborted); derr != nil {
+		err = mergeErrors(err, derr)
+	}
+	if state != TxnCommitted {
+		if _, aerr := r.completeTxn(rec.Id); aerr != nil {
+			r.logger().Error("Failed to abort transaction", "txn", rec.Id, "error", aerr)
+		}
+		return &TxnAbortedError{Id: rec.Id, Err: err}
+	}
+
+	// The transaction is committed. Owners that miss the commit are
+	// sent it again by recover, so only the replication can fail.
+	items, err := r.completeTxn(rec.Id)
+	if err != nil {
+		r.logger().Warn("Failed to commit transaction, will retry", "txn", rec.Id, "error", err)
+	}
+	err = nil
+	for _, item := range items {
+		err = mergeErrors(err, r.write(item, o))
+	}
+	if err != nil {
+		return &TxnReplicationError{Id: rec.Id, Err: err}
+	}
+	return nil
+}
+
+// Sends the outcome of a transaction to the owners that have not
+// acknowledged it, forgetting the transaction once all of them have.
+// Returns the items stored by a commit.
+func (r *Ring) completeTxn(id string) ([]*Item, error) {
+	rec, ok := r.txns.get(id)
+	if !ok {
+		return nil, nil
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var items []*Item
+	var err error
+	for idx, vn := range rec.Participants {
+		if rec.Done[idx] {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, vn *Vnode) {
+			defer wg.Done()
+			var stored []*Item
+			var terr error
+			if rec.State == TxnCommitted {
+				stored, terr = r.transport.TxnCommit(vn, id)
+			} else {
+				terr = r.transport.TxnAbort(vn, id)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if terr == errTxnUnknown {
+				// Retrying cannot help, so count it as acknowledged
+				r.logger().Error("Owner does not hold committed transaction, its writes may be lost",
+					vn.logFields("txn", id)...)
+			} else if terr != nil {
+				err = mergeErrors(err, &UnreachableError{Vnode: vn, Err: terr})
+				return
+			}
+			items = append(items, stored...)
+			rec.Done[idx] = true
+		}(idx, vn)
+	}
+	wg.Wait()
+	return items, mergeErrors(err, r.txns.save(rec))
+}
+
+// A transaction in the coordinator log
+type txnRecord struct {
+	Id           string
+	State        TxnState
+	Deadline     time.Time // Time the locks of the transaction expire
+	Participants []*Vnode  // Owners of the keys written
+	Done         []bool    // Owners that acknowledged the outcome
+}
+
+/*
+Logs the transactions we coordinate, so their outcome survives a
+restart. Records are kept in a Store, which is durable if
+Config.DataDir is set, and mirrored in memory. A record is dropped
+once every owner has acknowledged the outcome.
+*/
+type txnLog struct {
+	ring  *Ring
+	store Store
+	seq   atomic.Uint64
+
+	lock         sync.Mutex
+	records      map[string]*txnRecord
+	active       map[string]bool // Transactions still run by Txn
+	lastRecovery time.Time
+}
+
+// Opens the coordinator log of a ring, reloading any durable records
+func openTxnLog(r *Ring) (*txnLog, error) {
+	conf := r.config
+	l := &txnLog{ring: r, records: make(map[string]*txnRecord), active: make(map[string]bool)}
+	if conf.DataDir != "" {
+		store, err := OpenDiskStore(filepath.Join(conf.DataDir, txnDir), conf)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open transaction log! Got %s", err)
+		}
+		l.store = store
+	} else {
+		l.store = NewMemoryStore()
+	}
+	err := l.store.Range(nil, nil, func(item *Item) bool {
+		rec := &txnRecord{}
+		if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(rec); err != nil {
+			r.logger().Error("Dropping corrupt transaction record", "error", err)
+			return true
+		}
+		l.records[rec.Id] = rec
+		return true
+	})
+	if err != nil {
+		l.store.Close()
+		return nil, fmt.Errorf("Failed to open transaction log! Got %s", err)
+	}
+	return l, nil
+}
+
+// Returns a new transaction id, unique across restarts
+func (l *txnLog) newId() string {
+	return fmt.Sprintf("%s/%x.%d", l.ring.config.Hostname, time.Now().UnixNano(), l.seq.Add(1))
+}
+
+// Records a transaction about to be prepared
+func (l *txnLog) begin(rec *txnRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.put(rec); err != nil {
+		return err
+	}
+	l.records[rec.Id] = rec
+	l.active[rec.Id] = true
+	return nil
+}
+
+// Marks a transaction as no longer run by Txn
+func (l *txnLog) release(id string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.active, id)
+}
+
+// Returns a copy of a transaction record
+func (l *txnLog) get(id string) (*txnRecord, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	rec, ok := l.records[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *rec
+	cp.Done = append([]bool(nil), rec.Done...)
+	return &cp, true
+}
+
+// Records the outcome of a transaction, unless it was already decided.
+// Returns the outcome.
+func (l *txnLog) decide(id string, state TxnState) (TxnState, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	rec, ok := l.records[id]
+	if !ok {
+		return TxnAborted, nil
+	}
+	if rec.State != TxnPreparing {
+		return rec.State, nil
+	}
+	rec.State = state
+	if err := l.put(rec); err != nil {
+		rec.State = TxnPreparing
+		return TxnPreparing, err
+	}
+	return state, nil
+}
+
+// Returns the outcome of a transaction for an owner whose locks
+// expired. Transactions we no longer know of were aborted, and those
+// still preparing are aborted once they are past their deadline.
+func (l *txnLog) status(id string) (TxnState, error) {
+	l.lock.Lock()
+	rec, ok := l.records[id]
+	if !ok {
+		l.lock.Unlock()
+		return TxnAborted, nil
+	}
+	state, expired := rec.State, time.Now().After(rec.Deadline) || !l.active[id]
+	l.lock.Unlock()
+	if state == TxnPreparing && expired {
+		return l.decide(id, TxnAborted)
+	}
+	return state, nil
+}
+
+// Saves the acknowledgements of a transaction, dropping it once
+// every owner has acknowledged the outcome
+func (l *txnLog) save(rec *txnRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	cur, ok := l.records[rec.Id]
+	if !ok {
+		return nil
+	}
+	done := true
+	for idx := range cur.Done {
+		cur.Done[idx] = cur.Done[idx] || rec.Done[idx]
+		done = done && cur.Done[idx]
+	}
+	if done {
+		delete(l.records, rec.Id)
+		return l.store.Delete([]byte(rec.Id))
+	}
+	return l.put(cur)
+}
+
+// Writes a record to the store. The lock must be held.
+func (l *txnLog) put(rec *txnRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return err
+	}
+	key := []byte(rec.Id)
+	return l.store.Put(&Item{Key: key, Hash: l.ring.hashKey(key), Value: buf.Bytes()})
+}
+
+// Finishes the transactions left in doubt by a restart, or whose
+// owners could not be told the outcome. Runs at most once per
+// Config.StabilizeMin, since every vnode triggers it.
+func (l *txnLog) recover() {
+	l.lock.Lock()
+	if len(l.records) == 0 || time.Since(l.lastRecovery) < l.ring.config.StabilizeMin {
+		l.lock.Unlock()
+		return
+	}
+	l.lastRecovery = time.Now()
+	var ids []string
+	for id := range l.records {
+		if !l.active[id] {
+			ids = append(ids, id)
+			l.active[id] = true
+		}
+	}
+	l.lock.Unlock()
+
+	for _, id := range ids {
+		if _, err := l.decide(id, TxnAborted); err != nil {
+			l.ring.logger().Error("Failed to abort transaction", "txn", id, "error", err)
+		} else {
+			items, err := l.ring.completeTxn(id)
+			if err != nil {
+				l.ring.logger().Error("Failed to finish transaction", "txn", id, "error", err)
+			}
+			for _, item := range items {
+				l.ring.write(item, l.ring.options(nil))
+			}
+		}
+		l.release(id)
+	}
+}
+
+// Closes the underlying store
+func (l *txnLog) close() error {
+	return l.store.Close()
+}
+
+// Writes held by an owner until the outcome of a transaction is known.
+// Committed transactions are remembered without their writes until
+// Expires, so that the commit can be acknowledged again.
+type preparedTxn struct {
+	Coordinator *Vnode
+	Items       []*Item
+	Stored      []*Item // Items stored so far by the commit, in order
+	Expires     time.Time
+	Committed   bool
+}
+
+// Opens the store of the transactions prepared on a vnode, reloading
+// them and their locks if it is durable
+func (vn *LocalVnode) openTxns() error {
+	conf := vn.Ring.config
+	if conf.DataDir != "" {
+		dir := filepath.Join(conf.DataDir, preparedDir, fmt.Sprintf("%x", vn.Id))
+		store, err := OpenDiskStore(dir, conf)
+		if err != nil {
+			return fmt.Errorf("Failed to open prepared transactions! Got %s", err)
+		}
+		vn.txnStore = store
+	} else {
+		vn.txnStore = NewMemoryStore()
+	}
+
+	vn.prepared = make(map[string]*preparedTxn)
+	vn.txnLocks = make(map[string]string)
+	return vn.txnStore.Range(nil, nil, func(item *Item) bool {
+		txn := &preparedTxn{}
+		if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(txn); err != nil {
+			vn.Ring.logger().Error("Dropping corrupt prepared transaction", vn.logFields("error", err)...)
+			return true
+		}
+		id := string(item.Key)
+		vn.prepared[id] = txn
+		if !txn.Committed {
+			for _, held := range txn.Items {
+				vn.txnLocks[string(held.Key)] = id
+			}
+		}
+		return true
+	})
+}
+
+// Writes a prepared transaction to the store. The txn lock must be held.
+func (vn *LocalVnode) saveTxn(id string, txn *preparedTxn) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(txn); err != nil {
+		return err
+	}
+	key := []byte(id)
+	return vn.txnStore.Put(&Item{Key: key, Hash: vn.Ring.hashKey(key), Value: buf.Bytes()})
+}
+
+// RPC: Locks the keys written by a transaction and holds the writes
+// until it is committed or aborted. Fails if a key is locked by
+// another transaction.
+func (vn *LocalVnode) TxnPrepare(id string, coordinator *Vnode, items []*Item, timeout time.Duration) error {
+	if vn.left.Load() {
+		return errVnodeLeft
+	}
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	if _, ok := vn.prepared[id]; ok {
+		return nil
+	}
+	for _, item := range items {
+		if other, ok := vn.txnLocks[string(item.Key)]; ok && other != id {
+			return fmt.Errorf("Key %q is locked by transaction %s", item.Key, other)
+		}
+	}
+
+	txn := &preparedTxn{Coordinator: coordinator, Items: items, Expires: time.Now().Add(timeout)}
+	if err := vn.saveTxn(id, txn); err != nil {
+		return fmt.Errorf("Failed to save transaction %s! Got %s", id, err)
+	}
+	vn.prepared[id] = txn
+	for _, item := range items {
+		vn.txnLocks[string(item.Key)] = id
+	}
+	return nil
+}
+
+// RPC: Makes the writes of a prepared transaction and releases its
+// locks, returning the items stored. If a write fails, the ones made
+// before it are kept and not made again by the next attempt. A
+// transaction committed again stores nothing, while one that was
+// never prepared, whose writes were lost, or that was committed so
+// long ago it was forgotten, fails with errTxnUnknown.
+func (vn *LocalVnode) TxnCommit(id string) ([]*Item, error) {
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	txn, ok := vn.prepared[id]
+	if !ok {
+		return nil, errTxnUnknown
+	}
+	if txn.Committed {
+		return nil, nil
+	}
+
+	// Skip the writes made before an earlier attempt failed
+	for _, item := range txn.Items[len(txn.Stored):] {
+		put, err := vn.apply(item.Key, func(*Version, uint64) (*Version, error) {
+			return &Version{Value: item.Value, Deleted: item.Deleted, Expires: item.Expires}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		txn.Stored = append(txn.Stored, put)
+		if err := vn.saveTxn(id, txn); err != nil {
+			vn.Ring.logger().Warn("Failed to save transaction progress", vn.logFields("txn", id, "error", err)...)
+		}
+	}
+	stored := txn.Stored
+
+	// Remember the commit in place of the writes
+	done := &preparedTxn{Coordinator: txn.Coordinator, Expires: time.Now().Add(committedTxnTTL), Committed: true}
+	vn.releaseLocks(id, txn)
+	vn.prepared[id] = done
+	if err := vn.saveTxn(id, done); err != nil {
+		vn.Ring.logger().Warn("Failed to save committed transaction", vn.logFields("txn", id, "error", err)...)
+	}
+	return stored, nil
+}
+
+// RPC: Drops the writes of a prepared transaction and releases its locks
+func (vn *LocalVnode) TxnAbort(id string) error {
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	return vn.releaseTxn(id)
+}
+
+// Forgets a prepared transaction. The txn lock must be held.
+func (vn *LocalVnode) releaseTxn(id string) error {
+	txn, ok := vn.prepared[id]
+	if !ok {
+		return nil
+	}
+	vn.releaseLocks(id, txn)
+	delete(vn.prepared, id)
+	return vn.txnStore.Delete([]byte(id))
+}
+
+// Releases the keys locked by a transaction. The txn lock must be held.
+func (vn *LocalVnode) releaseLocks(id string, txn *preparedTxn) {
+	for _, item := range txn.Items {
+		if vn.txnLocks[string(item.Key)] == id {
+			delete(vn.txnLocks, string(item.Key))
+		}
+	}
+}
+
+// RPC: Returns the outcome of a transaction coordinated by our ring
+func (vn *LocalVnode) TxnStatus(id string) (TxnState, error) {
+	return vn.Ring.txns.status(id)
+}
+
+// Asks the coordinators of the transactions whose locks expired for
+// their outcome. Locks are kept while the coordinator cannot be
+// reached, until they are expired for another Config.TxnTimeout, and
+// the transaction is aborted. Committed transactions are forgotten
+// once they expire.
+func (vn *LocalVnode) resolveTxns() {
+	now := time.Now()
+	expired := make(map[string]*preparedTxn)
+	vn.txnLock.Lock()
+	for id, txn := range vn.prepared {
+		if !now.After(txn.Expires) {
+			continue
+		}
+		if txn.Committed {
+			if err := vn.releaseTxn(id); err != nil {
+				vn.Ring.logger().Warn("Failed to forget transaction", vn.logFields("txn", id, "error", err)...)
+			}
+		} else {
+			expired[id] = txn
+		}
+	}
+	vn.txnLock.Unlock()
+
+	giveUp := now.Add(-txnTimeout(vn.Ring.config))
+	for id, txn := range expired {
+		state, err := vn.Ring.transport.TxnStatus(txn.Coordinator, id)
+		if err != nil && txn.Expires.Before(giveUp) {
+			vn.Ring.logger().Warn("Aborting transaction of unreachable coordinator",
+				vn.logFields("txn", id, "error", err)...)
+			state = TxnAborted
+		} else if err != nil {
+			continue
+		}
+		switch state {
+		case TxnCommitted:
+			items, err := vn.TxnCommit(id)
+			if err != nil {
+				vn.Ring.logger().Error("Failed to commit transaction", vn.logFields("txn", id, "error", err)...)
+			}
+			for _, item := range items {
+				vn.Ring.write(item, vn.Ring.options(nil))
+			}
+		case TxnAborted:
+			vn.TxnAbort(id)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// Finds a key owned by a vnode of a host
+func keyOwnedBy(t *testing.T, r *Ring, host string) ([]byte, *Vnode) {
+	for i := 0; ; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		replicas, err := r.replicas(key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if replicas[0].Host == host {
+			return key, replicas[0]
+		}
+	}
+}
+
+func TestRingTxn(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	if err := r.Put([]byte("gone"), []byte("bar"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	var ops []*TxnOp
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		ops = append(ops, &TxnOp{Key: key, Value: key})
+	}
+	ops = append(ops, &TxnOp{Key: []byte("gone"), Delete: true})
+	if err := r.Txn(ops, WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	for _, op := range ops[:10] {
+		val, version, err := r2.GetWithVersion(op.Key)
+		if err != nil {
+			t.Fatalf("unexpected err for %s. %s", op.Key, err)
+		}
+		if !bytes.Equal(val, op.Value) || version != 1 {
+			t.Fatalf("bad value for %s: %s %d", op.Key, val, version)
+		}
+	}
+	if _, err := r2.Get([]byte("gone")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	r.txns.lock.Lock()
+	defer r.txns.lock.Unlock()
+	if len(r.txns.records) != 0 {
+		t.Fatalf("expected the transaction to be forgotten")
+	}
+}
+
+func TestRingTxnConflict(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Lock a key for a transaction whose coordinator is gone
+	key, owner := keyOwnedBy(t, r, "test")
+	var vn *LocalVnode
+	for _, local := range r.Vnodes {
+		if bytes.Equal(local.Id, owner.Id) {
+			vn = local
+		}
+	}
+	dead := &Vnode{Id: []byte{1}, Host: "dead"}
+	items := []*Item{{Key: key, Value: []byte("stale")}}
+	if err := vn.TxnPrepare("dead/1", dead, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Transactions writing the key abort, without writing any key
+	ops := []*TxnOp{
+		{Key: []byte("other"), Value: []byte("bar")},
+		{Key: key, Value: []byte("bar")},
+	}
+	err = r.Txn(ops)
+	if _, ok := err.(*TxnAbortedError); !ok {
+		t.Fatalf("expected abort, got %v", err)
+	}
+	if _, err := r.Get([]byte("other")); err != ErrKeyNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	for _, local := range r.Vnodes {
+		local.txnLock.Lock()
+		n := len(local.prepared)
+		local.txnLock.Unlock()
+		if n > 0 && local != vn {
+			t.Fatalf("locks not released on %s", local.String())
+		}
+	}
+
+	// The lock is kept while the coordinator cannot be reached
+	vn.txnLock.Lock()
+	vn.prepared["dead/1"].Expires = time.Now()
+	vn.txnLock.Unlock()
+	<-time.After(100 * time.Millisecond)
+	if err := r.Txn(ops); err == nil {
+		t.Fatalf("expected abort")
+	}
+
+	// Once expired, locks of transactions the coordinator does
+	// not know of are released
+	vn.txnLock.Lock()
+	vn.prepared["dead/1"].Coordinator = &r.Vnodes[0].Vnode
+	vn.txnLock.Unlock()
+	<-time.After(100 * time.Millisecond)
+	if err := r.Txn(ops); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if val, err := r.Get(key); err != nil || !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("unexpected result. %s %v", val, err)
+	}
+}
+
+func TestRingTxnDeadCoordinator(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Lock a key for a transaction whose coordinator is gone for good
+	key, owner := keyOwnedBy(t, r, "test")
+	vn := ringVnode(owner, r)
+	dead := &Vnode{Id: []byte{1}, Host: "dead"}
+	items := []*Item{{Key: key, Value: []byte("stale")}}
+	if err := vn.TxnPrepare("dead/1", dead, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Once expired for another timeout, the transaction is aborted
+	vn.txnLock.Lock()
+	vn.prepared["dead/1"].Expires = time.Now().Add(-txnTimeout(r.config))
+	vn.txnLock.Unlock()
+	<-time.After(100 * time.Millisecond)
+	if err := r.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	if len(vn.prepared) != 0 {
+		t.Fatalf("expected the transaction to be aborted")
+	}
+}
+
+func TestRingTxnOwnerLostCommit(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// A committed transaction whose owner does not hold it
+	rec := &txnRecord{Id: r.txns.newId(), Deadline: time.Now().Add(time.Minute),
+		Participants: []*Vnode{&r.Vnodes[0].Vnode}, Done: []bool{false}}
+	if err := r.txns.begin(rec); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := r.txns.decide(rec.Id, TxnCommitted); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.txns.release(rec.Id)
+
+	// The owner cannot commit it, so it is not retried
+	if _, err := r.completeTxn(rec.Id); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, ok := r.txns.get(rec.Id); ok {
+		t.Fatalf("expected the transaction to be forgotten")
+	}
+}
+
+func TestRingTxnLocksWrites(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Prepare a transaction writing a key
+	key, owner := keyOwnedBy(t, r, "test")
+	var vn *LocalVnode
+	for _, local := range r.Vnodes {
+		if bytes.Equal(local.Id, owner.Id) {
+			vn = local
+		}
+	}
+	if err := r.Put(key, []byte("old")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	items := []*Item{{Key: key, Value: []byte("txn")}}
+	if err := vn.TxnPrepare("test/1", owner, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Other writes to the key fail until the transaction commits
+	if err := r.Put(key, []byte("put")); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	if err := r.Delete(key); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	if _, err := r.CompareAndSwap(key, 1, []byte("cas")); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	if _, err := r.Increment(key, 1); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	if val, err := r.Get(key); err != nil || !bytes.Equal(val, []byte("old")) {
+		t.Fatalf("unexpected result. %s %v", val, err)
+	}
+
+	// Only prepared transactions can be committed
+	if _, err := vn.TxnCommit("test/2"); err != errTxnUnknown {
+		t.Fatalf("expected an unknown transaction, got %v", err)
+	}
+	stored, err := vn.TxnCommit("test/1")
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("unexpected result. %v %v", stored, err)
+	}
+	if stored, err := vn.TxnCommit("test/1"); err != nil || len(stored) != 0 {
+		t.Fatalf("expected a repeated commit to store nothing, got %v %v", stored, err)
+	}
+	if _, err := r.CompareAndSwap(key, stored[0].Version, []byte("cas")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+}
+
+func TestRingTxnUnderReplicated(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	ft := &failTrans{MultiLocalTrans: ml}
+	r, err := Create(kvConf("test"), ft)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ft, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// The replica cannot be written, but the transaction still commits
+	key, _ := keyOwnedBy(t, r, "test")
+	ft.fail("test2")
+	err = r.Txn([]*TxnOp{{Key: key, Value: []byte("bar")}}, WithWriteQuorum(2))
+	if _, ok := err.(*TxnReplicationError); !ok {
+		t.Fatalf("expected replication error, got %v", err)
+	}
+	if val, err := r.Get(key); err != nil || !bytes.Equal(val, []byte("bar")) {
+		t.Fatalf("unexpected result. %s %v", val, err)
+	}
+}
+
+// Store failing to write a key once
+type failOnceStore struct {
+	Store
+	key    []byte
+	failed bool
+}
+
+func (s *failOnceStore) Put(item *Item) error {
+	if !s.failed && bytes.Equal(item.Key, s.key) {
+		s.failed = true
+		return fmt.Errorf("put failed")
+	}
+	return s.Store.Put(item)
+}
+
+func TestTxnCommitRetry(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// The second write of a transaction fails once
+	vn := r.Vnodes[0]
+	vn.Store = &failOnceStore{Store: vn.Store, key: []byte("b")}
+	items := []*Item{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}}
+	if err := vn.TxnPrepare("test/1", &vn.Vnode, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if _, err := vn.TxnCommit("test/1"); err == nil {
+		t.Fatalf("expected err!")
+	}
+
+	// Committing again makes only the write that failed
+	stored, err := vn.TxnCommit("test/1")
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("unexpected result. %v %v", stored, err)
+	}
+	for _, key := range []string{"a", "b"} {
+		item, err := vn.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if item.Version != 1 {
+			t.Fatalf("expected %s to be written once, got version %d", key, item.Version)
+		}
+	}
+}
+
+func TestPreparedTxnDurable(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	conf := fastConf()
+	conf.DataDir = dir
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Stop while a transaction is prepared
+	key, owner := keyOwnedBy(t, r, "test")
+	idx := 0
+	for i, local := range r.Vnodes {
+		if bytes.Equal(local.Id, owner.Id) {
+			idx = i
+		}
+	}
+	items := []*Item{{Key: key, Value: []byte("txn")}}
+	if err := r.Vnodes[idx].TxnPrepare("test/1", owner, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Shutdown()
+
+	// Recreate the ring from the same directory
+	r, err = Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// The key is still locked, and the writes can be committed
+	vn := r.Vnodes[idx]
+	if _, err := vn.Increment(key, 1); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	stored, err := vn.TxnCommit("test/1")
+	if err != nil || len(stored) != 1 || !bytes.Equal(stored[0].Value, []byte("txn")) {
+		t.Fatalf("unexpected result. %v %v", stored, err)
+	}
+}
+
+func TestRingTxnRecovery(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Commit a transaction, stopping before the owner is told
+	key, owner := keyOwnedBy(t, r, "test2")
+	rec := &txnRecord{Id: r.txns.newId(), Deadline: time.Now().Add(time.Minute),
+		Participants: []*Vnode{owner}, Done: []bool{false}}
+	if err := r.txns.begin(rec); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	items := []*Item{{Key: key, Value: []byte("bar")}}
+	if err := ml.TxnPrepare(owner, rec.Id, &r.Vnodes[0].Vnode, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if state, err := r.txns.decide(rec.Id, TxnCommitted); err != nil || state != TxnCommitted {
+		t.Fatalf("unexpected result. %v %v", state, err)
+	}
+	r.txns.release(rec.Id)
+
+	// The commit is sent again
+	<-time.After(200 * time.Millisecond)
+	item, err := ml.Get(owner, key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if !bytes.Equal(item.Value, []byte("bar")) {
+		t.Fatalf("bad value: %s", item.Value)
+	}
+	if _, ok := r.txns.get(rec.Id); ok {
+		t.Fatalf("expected the transaction to be forgotten")
+	}
+}
+
+func TestTxnLogDurable(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	conf := fastConf()
+	conf.DataDir = dir
+	r, err := Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Stop while a transaction is being prepared
+	target := &Vnode{Id: []byte{1}, Host: "dead"}
+	rec := &txnRecord{Id: r.txns.newId(), Deadline: time.Now().Add(time.Minute),
+		Participants: []*Vnode{target}, Done: []bool{false}}
+	if err := r.txns.begin(rec); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r.Shutdown()
+
+	// Recreate the ring from the same directory
+	r, err = Create(conf, nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	<-time.After(100 * time.Millisecond)
+
+	// The transaction is aborted, but kept until the owner is told
+	got, ok := r.txns.get(rec.Id)
+	if !ok {
+		t.Fatalf("expected the transaction to be kept")
+	}
+	if got.State != TxnAborted {
+		t.Fatalf("expected abort, got %v", got.State)
+	}
+}
+
+func TestTCPTxn(t *testing.T) {
+	listen := "localhost:10046"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnode directly over TCP
+	vn := &r.Vnodes[0].Vnode
+	items := []*Item{{Key: []byte("foo"), Value: []byte("bar")}}
+	if err := trans.TxnPrepare(vn, "a", vn, items, time.Minute); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := trans.TxnPrepare(vn, "b", vn, items, time.Minute); err == nil {
+		t.Fatalf("expected lock conflict")
+	}
+	if _, err := trans.Increment(vn, []byte("foo"), 1); err != ErrKeyLocked {
+		t.Fatalf("expected locked key, got %v", err)
+	}
+	if _, err := trans.TxnCommit(vn, "b"); err != errTxnUnknown {
+		t.Fatalf("expected an unknown transaction, got %v", err)
+	}
+	if err := trans.TxnAbort(vn, "b"); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	stored, err := trans.TxnCommit(vn, "a")
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if len(stored) != 1 || !bytes.Equal(stored[0].Value, []byte("bar")) || stored[0].Version != 1 {
+		t.Fatalf("bad items: %v", stored)
+	}
+	if state, err := trans.TxnStatus(vn, "a"); err != nil || state != TxnAborted {
+		t.Fatalf("unexpected result. %v %v", state, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -41,6 +42,27 @@ func betweenRightIncl(id1, id2, key []byte) bool {
 		bytes.Compare(id2, key) >= 0
 }
 
+// Checks if a key is in the interval (id1, id2]. Equal
+// ID's are taken to cover the whole ring.
+func inInterval(id1, id2, key []byte) bool {
+	if bytes.Equal(id1, id2) {
+		return true
+	}
+	return betweenRightIncl(id1, id2, key)
+}
+
+// Sorts items by hash, in ring order starting after the given ID
+func sortItems(items []*Item, start []byte) {
+	sort.Slice(items, func(i, j int) bool {
+		iWrap := bytes.Compare(items[i].Hash, start) <= 0
+		jWrap := bytes.Compare(items[j].Hash, start) <= 0
+		if iWrap != jWrap {
+			return jWrap
+		}
+		return bytes.Compare(items[i].Hash, items[j].Hash) == -1
+	})
+}
+
 // Computes the offset by (n + 2^exp) % (2^mod)
 func powerOffset(id []byte, exp int, mod int) []byte {
 	// Copy the existing slice
@@ -109,6 +109,37 @@ func TestPowerOffset(t *testing.T) {
 	}
 }
 
+func TestInInterval(t *testing.T) {
+	k1 := []byte{10}
+	k2 := []byte{20}
+	if !inInterval(k1, k2, []byte{20}) {
+		t.Fatalf("expected right inclusive")
+	}
+	if inInterval(k1, k2, []byte{10}) {
+		t.Fatalf("expected left exclusive")
+	}
+	if !inInterval(k1, k1, []byte{99}) {
+		t.Fatalf("expected whole ring")
+	}
+}
+
+func TestSortItems(t *testing.T) {
+	items := []*Item{
+		&Item{Hash: []byte{5}},
+		&Item{Hash: []byte{30}},
+		&Item{Hash: []byte{12}},
+		&Item{Hash: []byte{1}},
+	}
+	sortItems(items, []byte{10})
+
+	expect := []byte{12, 30, 1, 5}
+	for idx, item := range items {
+		if item.Hash[0] != expect[idx] {
+			t.Fatalf("bad order at %d: %v", idx, item.Hash)
+		}
+	}
+}
+
 func TestMax(t *testing.T) {
 	if max(-10, 10) != 10 {
 		t.Fatalf("bad max")
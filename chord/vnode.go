@@ -1,19 +1,34 @@
 package chord
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	//"log"
 	"time"
 )
 
-// Converts the ID to string
+// Converts the ID to a short string, for display only.
+// Use ident or Equal to tell vnodes apart.
 func (vn *Vnode) String() string {
 	return fmt.Sprintf("%x", vn.Id)[:2]
 }
 
+// Returns a key unique to the vnode, made of the host and full ID
+func (vn *Vnode) ident() string {
+	return fmt.Sprintf("%s/%x", vn.Host, vn.Id)
+}
+
+// Equal checks if both vnodes have the same host and ID
+func (vn *Vnode) Equal(other *Vnode) bool {
+	if vn == nil || other == nil {
+		return vn == other
+	}
+	return vn.Host == other.Host && bytes.Equal(vn.Id, other.Id)
+}
+
 // Initializes a local vnode
-func (vn *LocalVnode) Init(idx int) {
+func (vn *LocalVnode) Init(idx int) error {
 	// Generate an ID
 	vn.genId(uint16(idx))
 
@@ -23,28 +38,135 @@ func (vn *LocalVnode) Init(idx int) {
 	// Initialize all state
 	vn.Successors = make([]*Vnode, vn.Ring.config.NumSuccessors)
 	vn.Finger = make([]*Vnode, vn.Ring.config.HashBits)
-	vn.Map = map[string]string{}
 
-	// Register with the RPC mechanism
-	vn.Ring.transport.Register(&vn.Vnode, vn)
+	// Open our store, reopening any data kept on disk
+	conf := vn.Ring.config
+	open := conf.StoreFunc
+	if open == nil && conf.DataDir != "" {
+		open = DiskStoreFunc(conf.DataDir, conf)
+	}
+	if open != nil {
+		store, err := open(&vn.Vnode)
+		if err != nil {
+			return fmt.Errorf("Failed to open store for vnode %s! Got %s", vn.String(), err)
+		}
+		vn.Store = store
+	} else {
+		vn.Store = NewMemoryStore()
+	}
+	if err := vn.openTxns(); err != nil {
+		vn.Store.Close()
+		return err
+	}
 
-	// Used to stablize network at regular intervels
-	vn.schedule()
+	// Register with the RPC mechanism. Stabilization is scheduled
+	// once the ring is set up, so each vnode runs a single timer.
+	vn.Ring.transport.Register(&vn.Vnode, vn)
+	return nil
 }
 
 // Schedules the Vnode to do regular maintenence
 func (vn *LocalVnode) schedule() {
 	// Setup our stabilize timer
+	vn.lock.Lock()
+	defer vn.lock.Unlock()
 	vn.Timer = time.AfterFunc(randStabilize(vn.Ring.config), vn.stabilize)
 }
 
-func (vn *LocalVnode) PutKey(value int) error {
-	vn.Test = value
+// RPC: Returns the item stored under a key on this vnode
+func (vn *LocalVnode) Get(key []byte) (*Item, error) {
+	return vn.Store.Get(key)
+}
+
+// RPC: Stores the versions of an item on this vnode, keeping
+// any stored version that was written concurrently. If we own
+// the key, a write adding versions advances its version, and
+// is refused while a prepared transaction holds the key.
+func (vn *LocalVnode) Put(item *Item) error {
+	put := *item
+	put.Hash = vn.Ring.hashKey(item.Key)
+	if !vn.owns(put.Hash) {
+		return vn.merge(&put, false)
+	}
+
+	vn.txnLock.Lock()
+	defer vn.txnLock.Unlock()
+	if _, ok := vn.txnLocks[string(put.Key)]; ok {
+		// Replicating versions we already have is still allowed
+		old, err := vn.Store.Get(put.Key)
+		if err != nil || lacksVersions(old, mergeItems(old, &put)) {
+			return ErrKeyLocked
+		}
+	}
+	return vn.merge(&put, true)
+}
+
+// Merges an item into our store, dropping the versions it supersedes.
+// If count is set, the version advances when the item adds versions.
+// Fails once we have started to leave the ring.
+func (vn *LocalVnode) merge(item *Item, count bool) error {
+	vn.writeLock.Lock()
+	defer vn.writeLock.Unlock()
+	if vn.left.Load() {
+		return errVnodeLeft
+	}
+	old, err := vn.Store.Get(item.Key)
+	if err == nil {
+		merged := mergeItems(old, item)
+		if count && lacksVersions(old, merged) {
+			merged.Version++
+		}
+		item = merged
+	} else if err == ErrKeyNotFound {
+		if count {
+			counted := *item
+			counted.Version++
+			item = &counted
+		}
+	} else {
+		return err
+	}
+	if err := vn.Store.Put(item); err != nil {
+		return err
+	}
+	vn.notifyWatchers(item.Key, item)
 	return nil
 }
 
-func (vn *LocalVnode) GetKey() (int, error) {
-	return vn.Test, nil
+// Checks if we own a hash, being the first vnode after it on the ring.
+// Assumes we do if our predecessor is not known, since counting a write
+// on a replica only fails a later swap, while missing one on the owner
+// lets a stale swap succeed.
+func (vn *LocalVnode) owns(hash []byte) bool {
+	pred := vn.predecessor()
+	return pred == nil || betweenRightIncl(pred.Id, vn.Id, hash)
+}
+
+// RPC: Deletes a key on this vnode by writing a tombstone superseding
+// every stored version, the same way an update does
+func (vn *LocalVnode) Delete(key []byte) error {
+	_, err := vn.update(key, func(*Version, uint64) (*Version, error) {
+		return &Version{Deleted: time.Now()}, nil
+	})
+	return err
+}
+
+// RPC: Lists up to limit items whose key hash is in (start, end],
+// ordered around the ring from start. A limit <= 0 returns everything.
+func (vn *LocalVnode) Scan(start, end []byte, limit int) ([]*Item, error) {
+	var items []*Item
+	err := vn.Store.Range(start, end, func(item *Item) bool {
+		items = append(items, item)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortItems(items, start)
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
 }
 /*
 Notify(target, self *Vnode) ([]*Vnode, error)
@@ -71,11 +193,14 @@ func (vn *LocalVnode) genId(idx uint16) {
 // Called to periodically stabilize the vnode
 func (vn *LocalVnode) stabilize() {
 	// Clear the timer
+	vn.lock.Lock()
 	vn.Timer = nil
+	vn.lock.Unlock()
 
 	// Check for shutdown
-	if vn.Ring.shutdown != nil {
-		vn.Ring.shutdown <- true
+	if shutdown := vn.Ring.stopping(); shutdown != nil {
+		vn.stopWatchers()
+		shutdown <- true
 		return
 	}
 
@@ -83,27 +208,85 @@ func (vn *LocalVnode) stabilize() {
 	defer vn.schedule()
 
 	// Check for new successor
+	var failed error
 	if err := vn.CheckNewSuccessor(); err != nil {
-		//log.Printf("[ERR] Error checking for new successor: %s", err)
+		failed = vn.stabilizeFailed(failed, "Error checking for new successor", err)
 	}
 
 	// Notify the successor
 	if err := vn.notifySuccessor(); err != nil {
-		//log.Printf("[ERR] Error notifying successor: %s", err)
+		failed = vn.stabilizeFailed(failed, "Error notifying successor", err)
 	}
 
 	// Finger table fix up
 	if err := vn.FixFingerTable(); err != nil {
-		//log.Printf("[ERR] Error fixing finger table: %s", err)
+		failed = vn.stabilizeFailed(failed, "Error fixing finger table", err)
 	}
 
 	// Check the predecessor
 	if err := vn.checkPredecessor(); err != nil {
-		//log.Printf("[ERR] Error checking predecessor: %s", err)
+		failed = vn.stabilizeFailed(failed, "Error checking predecessor", err)
 	}
 
-	// Set the last stabilized time
+	// Hand off keys to new predecessors
+	if err := vn.transferHandoffs(); err != nil {
+		failed = vn.stabilizeFailed(failed, "Error handing off keys", err)
+	}
+
+	// Expire values and purge old tombstones
+	vn.maybeSweep()
+
+	// Sync up with our replicas
+	if err := vn.maybeAntiEntropy(); err != nil {
+		failed = vn.stabilizeFailed(failed, "Error syncing with replicas", err)
+	}
+
+	// Deliver writes held for replicas that are back
+	vn.Ring.hints.deliver()
+
+	// Renew our watches, moving them to the vnodes now owning their
+	// keys, and drop the watches of others no longer renewed
+	vn.Ring.refreshWatches()
+	vn.expireWatchers()
+
+	// Resolve the transactions whose locks expired, and
+	// finish those we coordinated that are still in doubt
+	vn.resolveTxns()
+	vn.Ring.txns.recover()
+
+	// Set the last stabilized time, and track the failures
+	vn.lock.Lock()
 	vn.Stabilized = time.Now()
+	if failed != nil {
+		vn.lastError = failed
+		vn.failures++
+	} else {
+		vn.failures = 0
+	}
+	failures := vn.failures
+	vn.lock.Unlock()
+	if failures == unhealthyAfter(vn.Ring.config) {
+		vn.Ring.logger().Error("Vnode failed to stabilize repeatedly", vn.logFields("failures", failures)...)
+	}
+}
+
+// Logs and publishes the failure of a stabilization step,
+// returning it merged with the earlier failures
+func (vn *LocalVnode) stabilizeFailed(failed error, step string, err error) error {
+	vn.Ring.logger().Warn(step, vn.logFields("error", err)...)
+	err = fmt.Errorf("%s: %s", step, err)
+	vn.Ring.emit(&Event{Type: EventStabilizeFailed, Local: &vn.Vnode, Err: err})
+	return mergeErrors(failed, err)
+}
+
+// Publishes a change of our first successor
+func (vn *LocalVnode) successorChanged(prev, next *Vnode) {
+	vn.Ring.emit(&Event{Type: EventSuccessorChanged, Local: &vn.Vnode, Old: prev, New: next})
+}
+
+// Publishes a change of our predecessor
+func (vn *LocalVnode) predecessorChanged(prev, next *Vnode) {
+	vn.Ring.emit(&Event{Type: EventPredecessorChanged, Local: &vn.Vnode, Old: prev, New: next})
 }
 
 // Checks for a new successor
@@ -112,7 +295,7 @@ func (vn *LocalVnode) CheckNewSuccessor() error {
 	trans := vn.Ring.transport
 
 CHECK_NEW_SUC:
-	succ := vn.Successors[0]
+	succ := vn.successor()
 	if succ == nil {
 		panic("Node has no successor!")
 	}
@@ -122,15 +305,34 @@ CHECK_NEW_SUC:
 		known := vn.knownSuccessors()
 		if known > 1 {
 			for i := 0; i < known; i++ {
-				if alive, _ := trans.Ping(vn.Successors[0]); !alive {
-					// Don't eliminate the last successor we know of
+				first := vn.successor()
+				if alive, _ := trans.Ping(first); !alive {
+					// Don't eliminate the last successor we know of, unless
+					// the next of our own vnodes is alive to take its place
 					if i+1 == known {
+						if next := vn.nextLocalVnode(); next != nil {
+							if alive, _ := trans.Ping(next); alive {
+								vn.lock.Lock()
+								vn.Successors[0] = next
+								vn.lock.Unlock()
+								vn.successorChanged(first, next)
+								goto CHECK_NEW_SUC
+							}
+						}
 						return fmt.Errorf("All known successors dead!")
 					}
 
 					// Advance the successors list past the dead one
-					copy(vn.Successors[0:], vn.Successors[1:])
-					vn.Successors[known-1-i] = nil
+					vn.lock.Lock()
+					advanced := vn.Successors[0] == first
+					if advanced {
+						vn.advanceSuccessors()
+					}
+					next := vn.Successors[0]
+					vn.lock.Unlock()
+					if advanced {
+						vn.successorChanged(first, next)
+					}
 				} else {
 					// Found live successor, check for new one
 					goto CHECK_NEW_SUC
@@ -145,8 +347,16 @@ CHECK_NEW_SUC:
 		// Check if new successor is alive before switching
 		alive, err := trans.Ping(maybe_suc)
 		if alive && err == nil {
-			copy(vn.Successors[1:], vn.Successors[0:len(vn.Successors)-1])
-			vn.Successors[0] = maybe_suc
+			vn.lock.Lock()
+			replaced := vn.Successors[0] == succ
+			if replaced {
+				copy(vn.Successors[1:], vn.Successors[0:len(vn.Successors)-1])
+				vn.Successors[0] = maybe_suc
+			}
+			vn.lock.Unlock()
+			if replaced {
+				vn.successorChanged(succ, maybe_suc)
+			}
 		} else {
 			return err
 		}
@@ -156,13 +366,13 @@ CHECK_NEW_SUC:
 
 // RPC: Invoked to return out predecessor
 func (vn *LocalVnode) GetPredecessor() (*Vnode, error) {
-	return vn.Predecessor, nil
+	return copyVnode(vn.predecessor()), nil
 }
 
 // Notifies our successor of us, updates successor list
 func (vn *LocalVnode) notifySuccessor() error {
 	// Notify successor
-	succ := vn.Successors[0]
+	succ := vn.successor()
 	succ_list, err := vn.Ring.transport.Notify(succ, &vn.Vnode)
 	if err != nil {
 		return err
@@ -174,36 +384,64 @@ func (vn *LocalVnode) notifySuccessor() error {
 		succ_list = succ_list[:max_succ-1]
 	}
 
-	// Update local successors list
-	for idx, s := range succ_list {
+	// Update local successors list, unless our successor changed
+	vn.lock.Lock()
+	defer vn.lock.Unlock()
+	if vn.Successors[0] != succ {
+		return nil
+	}
+	idx := 0
+	for _, s := range succ_list {
 		if s == nil {
 			break
 		}
 		// Ensure we don't set ourselves as a successor!
-		if s == nil || s.String() == vn.String() {
+		if s == nil || s.Equal(&vn.Vnode) {
 			break
 		}
 		vn.Successors[idx+1] = s
+		idx++
+	}
+
+	// Clear stale successors past the end of the new list, so
+	// vnodes that have left do not linger
+	for i := idx + 1; i < len(vn.Successors); i++ {
+		vn.Successors[i] = nil
 	}
 	return nil
 }
 
 // RPC: Notify is invoked when a Vnode gets notified
 func (vn *LocalVnode) Notify(maybe_pred *Vnode) ([]*Vnode, error) {
+	vn.lock.Lock()
+
 	// Check if we should update our predecessor
-	if vn.Predecessor == nil || between(vn.Predecessor.Id, vn.Id, maybe_pred.Id) {
-		// Inform the delegate
+	var changed bool
+	old := vn.Predecessor
+	if old == nil || between(old.Id, vn.Id, maybe_pred.Id) {
+		// The new predecessor takes over part of our keys
+		vn.queueHandoff(old, maybe_pred)
+		vn.Predecessor = maybe_pred
+		changed = true
+	}
+
+	// Return a copy of our successors list
+	succs := copyVnodes(vn.Successors)
+	vn.lock.Unlock()
+
+	// Inform the delegate and subscribers. Unless we had no
+	// predecessor, the new one joined between it and us.
+	if changed {
 		conf := vn.Ring.config
-		old := vn.Predecessor
 		vn.Ring.invokeDelegate(func() {
 			conf.Delegate.NewPredecessor(&vn.Vnode, maybe_pred, old)
 		})
-
-		vn.Predecessor = maybe_pred
+		vn.predecessorChanged(old, maybe_pred)
+		if old != nil {
+			vn.Ring.emit(&Event{Type: EventVnodeJoined, Local: &vn.Vnode, New: maybe_pred})
+		}
 	}
-
-	// Return our successors list
-	return vn.Successors, nil
+	return succs, nil
 }
 
 // Fixes up the finger table
@@ -211,9 +449,10 @@ func (vn *LocalVnode) FixFingerTable() error {
 
 	// Determine the offset
 	hb := vn.Ring.config.HashBits
-	offset := powerOffset(vn.Id, vn.Last_finger, hb)
-
-	//fmt.Println(offset)
+	vn.lock.RLock()
+	last := vn.Last_finger
+	vn.lock.RUnlock()
+	offset := powerOffset(vn.Id, last, hb)
 
 	// Find the successor
 	nodes, err := vn.FindSuccessors(1, offset)
@@ -222,14 +461,20 @@ func (vn *LocalVnode) FixFingerTable() error {
 	}
 	node := nodes[0]
 
-	// Update the finger table
-	vn.Finger[vn.Last_finger] = node
+	// Update the finger table, noting the entries that change
+	var events []*Event
+	setFinger := func(idx int) {
+		if old := vn.Finger[idx]; !old.Equal(node) {
+			events = append(events, &Event{Type: EventFingerUpdated,
+				Local: &vn.Vnode, Old: old, New: node, Finger: idx})
+		}
+		vn.Finger[idx] = node
+	}
+	vn.lock.Lock()
+	setFinger(vn.Last_finger)
 
 	// Try to skip as many finger entries as possible
 	for {
-
-		//fmt.Println(vn.Last_finger)
-
 		next := vn.Last_finger + 1
 		if next >= hb {
 			break
@@ -238,7 +483,7 @@ func (vn *LocalVnode) FixFingerTable() error {
 
 		// While the node is the successor, update the finger entries
 		if betweenRightIncl(vn.Id, node.Id, offset) {
-			vn.Finger[next] = node
+			setFinger(next)
 			vn.Last_finger = next
 		} else {
 			break
@@ -251,22 +496,35 @@ func (vn *LocalVnode) FixFingerTable() error {
 	} else {
 		vn.Last_finger++
 	}
+	vn.lock.Unlock()
 
+	for _, ev := range events {
+		vn.Ring.emit(ev)
+	}
 	return nil
 }
 
 // Checks the health of our predecessor
 func (vn *LocalVnode) checkPredecessor() error {
 	// Check predecessor
-	if vn.Predecessor != nil {
-		res, err := vn.Ring.transport.Ping(vn.Predecessor)
+	pred := vn.predecessor()
+	if pred != nil {
+		res, err := vn.Ring.transport.Ping(pred)
 		if err != nil {
 			return err
 		}
 
-		// Predecessor is dead
+		// Predecessor is dead, unless another one replaced it
 		if !res {
-			vn.Predecessor = nil
+			vn.lock.Lock()
+			cleared := vn.Predecessor == pred
+			if cleared {
+				vn.Predecessor = nil
+			}
+			vn.lock.Unlock()
+			if cleared {
+				vn.predecessorChanged(pred, nil)
+			}
 		}
 	}
 	return nil
@@ -275,8 +533,9 @@ func (vn *LocalVnode) checkPredecessor() error {
 // Finds next N successors. N must be <= NumSuccessors
 func (vn *LocalVnode) FindSuccessors(n int, key []byte) ([]*Vnode, error) {
 	// Check if we are the immediate predecessor
-	if betweenRightIncl(vn.Id, vn.Successors[0].Id, key) {
-		return vn.Successors[:n], nil
+	succs := vn.successors()
+	if betweenRightIncl(vn.Id, succs[0].Id, key) {
+		return copyVnodes(succs[:n]), nil
 	}
 
 	// Try the closest preceeding nodes
@@ -294,21 +553,21 @@ func (vn *LocalVnode) FindSuccessors(n int, key []byte) ([]*Vnode, error) {
 		if err == nil {
 			return res, nil
 		} else {
-			//log.Printf("[ERR] Failed to contact %s. Got %s", closest.String(), err)
+			vn.Ring.logger().Debug("Failed to contact vnode", closest.logFields("error", err)...)
 		}
 	}
 
 	// Determine how many successors we know of
-	successors := vn.knownSuccessors()
+	successors := knownVnodes(succs)
 
 	// Check if the ID is between us and any non-immediate successors
 	for i := 1; i <= successors-n; i++ {
-		if betweenRightIncl(vn.Id, vn.Successors[i].Id, key) {
-			remain := vn.Successors[i:]
+		if betweenRightIncl(vn.Id, succs[i].Id, key) {
+			remain := succs[i:]
 			if len(remain) > n {
 				remain = remain[:n]
 			}
-			return remain, nil
+			return copyVnodes(remain), nil
 		}
 	}
 
@@ -316,38 +575,72 @@ func (vn *LocalVnode) FindSuccessors(n int, key []byte) ([]*Vnode, error) {
 	return nil, fmt.Errorf("Exhausted all preceeding nodes!")
 }
 
-// Instructs the vnode to leave
+// Instructs the vnode to leave. Our keys are first handed off to
+// our successor, and we only detach from the ring once it has
+// acknowledged them or Config.LeaveTimeout expires.
 func (vn *LocalVnode) Leave() error {
-	// Inform the delegate we are leaving
+	deadline := time.Now().Add(leaveTimeout(vn.Ring.config))
+	return vn.leave(vn.successor(), deadline)
+}
+
+// Hands off our keys to the target and then detaches from the ring
+func (vn *LocalVnode) leave(target *Vnode, deadline time.Time) error {
+	// Stop accepting new keys before draining, so none are left behind.
+	// Writes check under the write lock, so any in progress are done.
+	vn.writeLock.Lock()
+	vn.left.Store(true)
+	vn.writeLock.Unlock()
+
+	herr := vn.drain(target, deadline)
+	err := vn.detach()
+	if herr != nil {
+		herr.Err = mergeErrors(herr.Err, err)
+		return herr
+	}
+	return err
+}
+
+// Informs our neighbors that we are leaving the ring
+func (vn *LocalVnode) detach() error {
+	// Inform the delegate and subscribers we are leaving
 	conf := vn.Ring.config
-	pred := vn.Predecessor
-	succ := vn.Successors[0]
+	pred := vn.predecessor()
+	succ := vn.successor()
 	vn.Ring.invokeDelegate(func() {
 		conf.Delegate.Leaving(&vn.Vnode, pred, succ)
 	})
+	vn.Ring.emit(&Event{Type: EventVnodeLeft, Local: &vn.Vnode, Old: &vn.Vnode})
 
 	// Notify predecessor to advance to their next successor
 	var err error
 	trans := vn.Ring.transport
-	if vn.Predecessor != nil {
-		err = trans.SkipSuccessor(vn.Predecessor, &vn.Vnode)
+	if pred != nil {
+		err = trans.SkipSuccessor(pred, &vn.Vnode)
 	}
 
 	// Notify successor to clear old predecessor
-	err = mergeErrors(err, trans.ClearPredecessor(vn.Successors[0], &vn.Vnode))
+	err = mergeErrors(err, trans.ClearPredecessor(succ, &vn.Vnode))
 	return err
 }
 
 // Used to clear our predecessor when a node is leaving
 func (vn *LocalVnode) ClearPredecessor(p *Vnode) error {
-	if vn.Predecessor != nil && vn.Predecessor.String() == p.String() {
-		// Inform the delegate
+	vn.lock.Lock()
+	old := vn.Predecessor
+	cleared := old != nil && old.Equal(p)
+	if cleared {
+		vn.Predecessor = nil
+	}
+	vn.lock.Unlock()
+
+	// Inform the delegate and subscribers
+	if cleared {
 		conf := vn.Ring.config
-		old := vn.Predecessor
 		vn.Ring.invokeDelegate(func() {
 			conf.Delegate.PredecessorLeaving(&vn.Vnode, old)
 		})
-		vn.Predecessor = nil
+		vn.Ring.emit(&Event{Type: EventVnodeLeft, Local: &vn.Vnode, Old: old})
+		vn.predecessorChanged(old, nil)
 	}
 	return nil
 }
@@ -355,26 +648,100 @@ func (vn *LocalVnode) ClearPredecessor(p *Vnode) error {
 // Used to skip a successor when a node is leaving
 func (vn *LocalVnode) SkipSuccessor(s *Vnode) error {
 	// Skip if we have a match
-	if vn.Successors[0].String() == s.String() {
-		// Inform the delegate
+	vn.lock.Lock()
+	old := vn.Successors[0]
+	skipped := old.Equal(s)
+	if skipped {
+		vn.advanceSuccessors()
+	}
+	next := vn.Successors[0]
+	vn.lock.Unlock()
+
+	// Inform the delegate and subscribers
+	if skipped {
 		conf := vn.Ring.config
-		old := vn.Successors[0]
 		vn.Ring.invokeDelegate(func() {
 			conf.Delegate.SuccessorLeaving(&vn.Vnode, old)
 		})
+		vn.Ring.emit(&Event{Type: EventVnodeLeft, Local: &vn.Vnode, Old: old})
+		vn.successorChanged(old, next)
+	}
+	return nil
+}
 
-		known := vn.knownSuccessors()
-		copy(vn.Successors[0:], vn.Successors[1:])
-		vn.Successors[known-1] = nil
+// Drops our first successor, moving up the others. The lock must be held.
+func (vn *LocalVnode) advanceSuccessors() {
+	known := knownVnodes(vn.Successors)
+	copy(vn.Successors[0:], vn.Successors[1:])
+	vn.Successors[known-1] = nil
+}
+
+// Returns a copy of our successors list
+func (vn *LocalVnode) successors() []*Vnode {
+	vn.lock.RLock()
+	defer vn.lock.RUnlock()
+	return append([]*Vnode(nil), vn.Successors...)
+}
+
+// Returns our immediate successor
+func (vn *LocalVnode) successor() *Vnode {
+	vn.lock.RLock()
+	defer vn.lock.RUnlock()
+	return vn.Successors[0]
+}
+
+// Returns our predecessor, nil if not known
+func (vn *LocalVnode) predecessor() *Vnode {
+	vn.lock.RLock()
+	defer vn.lock.RUnlock()
+	return vn.Predecessor
+}
+
+// Copies a vnode, so it can be handed out
+func copyVnode(vn *Vnode) *Vnode {
+	if vn == nil {
+		return nil
+	}
+	cp := *vn
+	return &cp
+}
+
+// Copies a list of vnodes, keeping nil entries
+func copyVnodes(vns []*Vnode) []*Vnode {
+	out := make([]*Vnode, len(vns))
+	for idx, vn := range vns {
+		out[idx] = copyVnode(vn)
+	}
+	return out
+}
+
+// Returns the next of our ring's vnodes after us, or nil
+// if we are the only one
+func (vn *LocalVnode) nextLocalVnode() *Vnode {
+	vnodes := vn.Ring.Vnodes
+	if len(vnodes) < 2 {
+		return nil
+	}
+	for idx, local := range vnodes {
+		if local == vn {
+			return &vnodes[(idx+1)%len(vnodes)].Vnode
+		}
 	}
 	return nil
 }
 
 // Determine how many successors we know of
-func (vn *LocalVnode) knownSuccessors() (successors int) {
-	for i := 0; i < len(vn.Successors); i++ {
-		if vn.Successors[i] != nil {
-			successors = i + 1
+func (vn *LocalVnode) knownSuccessors() int {
+	vn.lock.RLock()
+	defer vn.lock.RUnlock()
+	return knownVnodes(vn.Successors)
+}
+
+// Returns the length of a list of vnodes, ignoring the nil entries at its end
+func knownVnodes(vns []*Vnode) (known int) {
+	for i := 0; i < len(vns); i++ {
+		if vns[i] != nil {
+			known = i + 1
 		}
 	}
 	return
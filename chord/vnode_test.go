@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-func makeVnode() *localVnode {
+func makeVnode() *LocalVnode {
 	min := time.Duration(10 * time.Second)
 	max := time.Duration(30 * time.Second)
 	conf := &Config{
@@ -16,24 +16,24 @@ func makeVnode() *localVnode {
 		StabilizeMin:  min,
 		StabilizeMax:  max,
 		HashFunc:      sha1.New}
-	trans := InitLocalTransport(nil)
-	ring := &Ring{config: conf, transport: trans}
-	return &localVnode{ring: ring}
+	ring := &Ring{}
+	ring.init(conf, nil)
+	return &LocalVnode{Ring: ring}
 }
 
 func TestVnodeInit(t *testing.T) {
 	vn := makeVnode()
-	vn.init(0)
+	vn.Init(0)
 	if vn.Id == nil {
 		t.Fatalf("unexpected nil")
 	}
-	if vn.successors == nil {
+	if vn.Successors == nil {
 		t.Fatalf("unexpected nil")
 	}
-	if vn.finger == nil {
+	if vn.Finger == nil {
 		t.Fatalf("unexpected nil")
 	}
-	if vn.timer != nil {
+	if vn.Timer != nil {
 		t.Fatalf("unexpected timer")
 	}
 }
@@ -41,7 +41,7 @@ func TestVnodeInit(t *testing.T) {
 func TestVnodeSchedule(t *testing.T) {
 	vn := makeVnode()
 	vn.schedule()
-	if vn.timer == nil {
+	if vn.Timer == nil {
 		t.Fatalf("unexpected nil")
 	}
 }
@@ -66,17 +66,17 @@ func TestGenId(t *testing.T) {
 func TestVnodeStabilizeShutdown(t *testing.T) {
 	vn := makeVnode()
 	vn.schedule()
-	vn.ring.shutdown = make(chan bool, 1)
+	vn.Ring.shutdown = make(chan bool, 1)
 	vn.stabilize()
 
-	if vn.timer != nil {
+	if vn.Timer != nil {
 		t.Fatalf("unexpected timer")
 	}
-	if !vn.stabilized.IsZero() {
+	if !vn.Stabilized.IsZero() {
 		t.Fatalf("unexpected time")
 	}
 	select {
-	case <-vn.ring.shutdown:
+	case <-vn.Ring.shutdown:
 		return
 	default:
 		t.Fatalf("expected message")
@@ -85,27 +85,27 @@ func TestVnodeStabilizeShutdown(t *testing.T) {
 
 func TestVnodeStabilizeResched(t *testing.T) {
 	vn := makeVnode()
-	vn.init(1)
-	vn.successors[0] = &vn.Vnode
+	vn.Init(1)
+	vn.Successors[0] = &vn.Vnode
 	vn.schedule()
 	vn.stabilize()
 
-	if vn.timer == nil {
+	if vn.Timer == nil {
 		t.Fatalf("expected timer")
 	}
-	if vn.stabilized.IsZero() {
+	if vn.Stabilized.IsZero() {
 		t.Fatalf("expected time")
 	}
-	vn.timer.Stop()
+	vn.Timer.Stop()
 }
 
 func TestVnodeKnownSucc(t *testing.T) {
 	vn := makeVnode()
-	vn.init(0)
+	vn.Init(0)
 	if vn.knownSuccessors() != 0 {
 		t.Fatalf("wrong num known!")
 	}
-	vn.successors[0] = &Vnode{Id: []byte{1}}
+	vn.Successors[0] = &Vnode{Id: []byte{1}}
 	if vn.knownSuccessors() != 1 {
 		t.Fatalf("wrong num known!")
 	}
@@ -119,30 +119,30 @@ func TestVnodeCheckNewSuccAlivePanic(t *testing.T) {
 		}
 	}()
 	vn1 := makeVnode()
-	vn1.init(1)
-	vn1.checkNewSuccessor()
+	vn1.Init(1)
+	vn1.CheckNewSuccessor()
 }
 
 // Checks pinging a live successor with no changes
 func TestVnodeCheckNewSuccAlive(t *testing.T) {
 	vn1 := makeVnode()
-	vn1.init(1)
+	vn1.Init(1)
 
 	vn2 := makeVnode()
-	vn2.ring = vn1.ring
-	vn2.init(2)
-	vn2.predecessor = &vn1.Vnode
-	vn1.successors[0] = &vn2.Vnode
+	vn2.Ring = vn1.Ring
+	vn2.Init(2)
+	vn2.Predecessor = &vn1.Vnode
+	vn1.Successors[0] = &vn2.Vnode
 
-	if pred, _ := vn2.GetPredecessor(); pred != &vn1.Vnode {
+	if pred, _ := vn2.GetPredecessor(); !pred.Equal(&vn1.Vnode) {
 		t.Fatalf("expected vn1 as predecessor")
 	}
 
-	if err := vn1.checkNewSuccessor(); err != nil {
+	if err := vn1.CheckNewSuccessor(); err != nil {
 		t.Fatalf("unexpected err %s", err)
 	}
 
-	if vn1.successors[0] != &vn2.Vnode {
+	if !vn1.Successors[0].Equal(&vn2.Vnode) {
 		t.Fatalf("unexpected successor!")
 	}
 }
@@ -150,14 +150,14 @@ func TestVnodeCheckNewSuccAlive(t *testing.T) {
 // Checks pinging a dead successor with no alternates
 func TestVnodeCheckNewSuccDead(t *testing.T) {
 	vn1 := makeVnode()
-	vn1.init(1)
-	vn1.successors[0] = &Vnode{Id: []byte{0}}
+	vn1.Init(1)
+	vn1.Successors[0] = &Vnode{Id: []byte{0}}
 
-	if err := vn1.checkNewSuccessor(); err == nil {
-		t.Fatalf("err!", err)
+	if err := vn1.CheckNewSuccessor(); err == nil {
+		t.Fatalf("expected err!")
 	}
 
-	if vn1.successors[0].String() != "00" {
+	if vn1.Successors[0].String() != "00" {
 		t.Fatalf("unexpected successor!")
 	}
 }
@@ -167,25 +167,25 @@ func TestVnodeCheckNewSuccDeadAlternate(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn3 := r.vnodes[2]
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn3 := r.Vnodes[2]
 
-	vn1.successors[0] = &vn2.Vnode
-	vn1.successors[1] = &vn3.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn3.predecessor = &vn2.Vnode
+	vn1.Successors[0] = &vn2.Vnode
+	vn1.Successors[1] = &vn3.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn3.Predecessor = &vn2.Vnode
 
 	// Remove vn2
 	(r.transport.(*LocalTransport)).Deregister(&vn2.Vnode)
 
 	// Should not get an error
-	if err := vn1.checkNewSuccessor(); err != nil {
+	if err := vn1.CheckNewSuccessor(); err != nil {
 		t.Fatalf("unexpected err %s", err)
 	}
 
 	// Should become vn3
-	if vn1.successors[0] != &vn3.Vnode {
+	if vn1.Successors[0] != &vn3.Vnode {
 		t.Fatalf("unexpected successor!")
 	}
 }
@@ -195,60 +195,83 @@ func TestVnodeCheckNewSuccAllDeadAlternates(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn3 := r.vnodes[2]
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn3 := r.Vnodes[2]
 
-	vn1.successors[0] = &vn2.Vnode
-	vn1.successors[1] = &vn3.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn3.predecessor = &vn2.Vnode
+	vn1.Successors[0] = &vn2.Vnode
+	vn1.Successors[1] = &vn3.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn3.Predecessor = &vn2.Vnode
 
 	// Remove vn2
 	(r.transport.(*LocalTransport)).Deregister(&vn2.Vnode)
 	(r.transport.(*LocalTransport)).Deregister(&vn3.Vnode)
 
 	// Should get an error
-	if err := vn1.checkNewSuccessor(); err.Error() != "All known successors dead!" {
+	if err := vn1.CheckNewSuccessor(); err.Error() != "All known successors dead!" {
 		t.Fatalf("unexpected err %s", err)
 	}
 
 	// Should just be vn3
-	if vn1.successors[0] != &vn3.Vnode {
+	if vn1.Successors[0] != &vn3.Vnode {
 		t.Fatalf("unexpected successor!")
 	}
 }
 
+// Checks pinging dead successors, with the next local vnode alive
+func TestVnodeCheckNewSuccNextLocal(t *testing.T) {
+	r := makeRing()
+	sort.Sort(r)
+
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+
+	vn1.Successors[0] = &Vnode{Id: []byte{1}, Host: "dead"}
+	vn1.Successors[1] = &Vnode{Id: []byte{2}, Host: "dead"}
+	vn2.Predecessor = &vn1.Vnode
+
+	// Should not get an error
+	if err := vn1.CheckNewSuccessor(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// Should fall back to vn2
+	if vn1.Successors[0] != &vn2.Vnode {
+		t.Fatalf("unexpected successor! %s", vn1.Successors[0])
+	}
+}
+
 // Checks pinging a successor, and getting a new successor
 func TestVnodeCheckNewSuccNewSucc(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn3 := r.vnodes[2]
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn3 := r.Vnodes[2]
 
-	vn1.successors[0] = &vn3.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn3.predecessor = &vn2.Vnode
+	vn1.Successors[0] = &vn3.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn3.Predecessor = &vn2.Vnode
 
 	// vn3 pred is vn2
-	if pred, _ := vn3.GetPredecessor(); pred != &vn2.Vnode {
+	if pred, _ := vn3.GetPredecessor(); !pred.Equal(&vn2.Vnode) {
 		t.Fatalf("expected vn2 as predecessor")
 	}
 
 	// Should not get an error
-	if err := vn1.checkNewSuccessor(); err != nil {
+	if err := vn1.CheckNewSuccessor(); err != nil {
 		t.Fatalf("unexpected err %s", err)
 	}
 
 	// Should become vn2
-	if vn1.successors[0] != &vn2.Vnode {
-		t.Fatalf("unexpected successor! %s", vn1.successors[0])
+	if !vn1.Successors[0].Equal(&vn2.Vnode) {
+		t.Fatalf("unexpected successor! %s", vn1.Successors[0])
 	}
 
 	// 2nd successor should become vn3
-	if vn1.successors[1] != &vn3.Vnode {
+	if !vn1.Successors[1].Equal(&vn3.Vnode) {
 		t.Fatalf("unexpected 2nd successor!")
 	}
 }
@@ -259,24 +282,24 @@ func TestVnodeCheckNewSuccNewSuccDead(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn3 := r.vnodes[2]
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn3 := r.Vnodes[2]
 
-	vn1.successors[0] = &vn3.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn3.predecessor = &vn2.Vnode
+	vn1.Successors[0] = &vn3.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn3.Predecessor = &vn2.Vnode
 
 	// Remove vn2
 	(r.transport.(*LocalTransport)).Deregister(&vn2.Vnode)
 
 	// Should not get an error
-	if err := vn1.checkNewSuccessor(); err != nil {
+	if err := vn1.CheckNewSuccessor(); err != nil {
 		t.Fatalf("unexpected err %s", err)
 	}
 
 	// Should stay vn3
-	if vn1.successors[0] != &vn3.Vnode {
+	if vn1.Successors[0] != &vn3.Vnode {
 		t.Fatalf("unexpected successor!")
 	}
 }
@@ -290,13 +313,13 @@ func TestVnodeNotifySucc(t *testing.T) {
 	s2 := &Vnode{Id: []byte{2}}
 	s3 := &Vnode{Id: []byte{3}}
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn1.successors[0] = &vn2.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn2.successors[0] = s1
-	vn2.successors[1] = s2
-	vn2.successors[2] = s3
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn1.Successors[0] = &vn2.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn2.Successors[0] = s1
+	vn2.Successors[1] = s2
+	vn2.Successors[2] = s3
 
 	// Should get no error
 	if err := vn1.notifySuccessor(); err != nil {
@@ -304,18 +327,18 @@ func TestVnodeNotifySucc(t *testing.T) {
 	}
 
 	// Successor list should be updated
-	if vn1.successors[1] != s1 {
+	if !vn1.Successors[1].Equal(s1) {
 		t.Fatalf("bad succ 1")
 	}
-	if vn1.successors[2] != s2 {
+	if !vn1.Successors[2].Equal(s2) {
 		t.Fatalf("bad succ 2")
 	}
-	if vn1.successors[3] != s3 {
+	if !vn1.Successors[3].Equal(s3) {
 		t.Fatalf("bad succ 3")
 	}
 
 	// Predecessor should not updated
-	if vn2.predecessor != &vn1.Vnode {
+	if vn2.Predecessor != &vn1.Vnode {
 		t.Fatalf("bad predecessor")
 	}
 }
@@ -325,10 +348,10 @@ func TestVnodeNotifySuccDead(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn1.successors[0] = &vn2.Vnode
-	vn2.predecessor = &vn1.Vnode
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn1.Successors[0] = &vn2.Vnode
+	vn2.Predecessor = &vn1.Vnode
 
 	// Remove vn2
 	(r.transport.(*LocalTransport)).Deregister(&vn2.Vnode)
@@ -347,28 +370,28 @@ func TestVnodeNotifySamePred(t *testing.T) {
 	s2 := &Vnode{Id: []byte{2}}
 	s3 := &Vnode{Id: []byte{3}}
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn1.successors[0] = &vn2.Vnode
-	vn2.predecessor = &vn1.Vnode
-	vn2.successors[0] = s1
-	vn2.successors[1] = s2
-	vn2.successors[2] = s3
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn1.Successors[0] = &vn2.Vnode
+	vn2.Predecessor = &vn1.Vnode
+	vn2.Successors[0] = s1
+	vn2.Successors[1] = s2
+	vn2.Successors[2] = s3
 
 	succs, err := vn2.Notify(&vn1.Vnode)
 	if err != nil {
 		t.Fatalf("unexpected error! %s", err)
 	}
-	if succs[0] != s1 {
+	if !succs[0].Equal(s1) {
 		t.Fatalf("unexpected succ 0")
 	}
-	if succs[1] != s2 {
+	if !succs[1].Equal(s2) {
 		t.Fatalf("unexpected succ 1")
 	}
-	if succs[2] != s3 {
+	if !succs[2].Equal(s3) {
 		t.Fatalf("unexpected succ 2")
 	}
-	if vn2.predecessor != &vn1.Vnode {
+	if vn2.Predecessor != &vn1.Vnode {
 		t.Fatalf("unexpected pred")
 	}
 }
@@ -381,26 +404,26 @@ func TestVnodeNotifyNoPred(t *testing.T) {
 	s2 := &Vnode{Id: []byte{2}}
 	s3 := &Vnode{Id: []byte{3}}
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn2.successors[0] = s1
-	vn2.successors[1] = s2
-	vn2.successors[2] = s3
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn2.Successors[0] = s1
+	vn2.Successors[1] = s2
+	vn2.Successors[2] = s3
 
 	succs, err := vn2.Notify(&vn1.Vnode)
 	if err != nil {
 		t.Fatalf("unexpected error! %s", err)
 	}
-	if succs[0] != s1 {
+	if !succs[0].Equal(s1) {
 		t.Fatalf("unexpected succ 0")
 	}
-	if succs[1] != s2 {
+	if !succs[1].Equal(s2) {
 		t.Fatalf("unexpected succ 1")
 	}
-	if succs[2] != s3 {
+	if !succs[2].Equal(s3) {
 		t.Fatalf("unexpected succ 2")
 	}
-	if vn2.predecessor != &vn1.Vnode {
+	if vn2.Predecessor != &vn1.Vnode {
 		t.Fatalf("unexpected pred")
 	}
 }
@@ -409,16 +432,16 @@ func TestVnodeNotifyNewPred(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn3 := r.vnodes[2]
-	vn3.predecessor = &vn1.Vnode
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn3 := r.Vnodes[2]
+	vn3.Predecessor = &vn1.Vnode
 
 	_, err := vn3.Notify(&vn2.Vnode)
 	if err != nil {
 		t.Fatalf("unexpected error! %s", err)
 	}
-	if vn3.predecessor != &vn2.Vnode {
+	if vn3.Predecessor != &vn2.Vnode {
 		t.Fatalf("unexpected pred")
 	}
 }
@@ -426,42 +449,42 @@ func TestVnodeNotifyNewPred(t *testing.T) {
 func TestVnodeFixFinger(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
-	num := len(r.vnodes)
+	num := len(r.Vnodes)
 	for i := 0; i < num; i++ {
-		r.vnodes[i].init(i)
-		r.vnodes[i].successors[0] = &r.vnodes[(i+1)%num].Vnode
+		r.Vnodes[i].Init(i)
+		r.Vnodes[i].Successors[0] = &r.Vnodes[(i+1)%num].Vnode
 	}
 
 	// Fix finger should not error
-	vn := r.vnodes[0]
-	if err := vn.fixFingerTable(); err != nil {
+	vn := r.Vnodes[0]
+	if err := vn.FixFingerTable(); err != nil {
 		t.Fatalf("unexpected err, %s", err)
 	}
 
 	// Check we've progressed
-	if vn.last_finger != 158 {
-		t.Fatalf("unexpected last finger! %d", vn.last_finger)
+	if vn.Last_finger != 158 {
+		t.Fatalf("unexpected last finger! %d", vn.Last_finger)
 	}
 
 	// Ensure that we've setup our successor as the initial entries
-	for i := 0; i < vn.last_finger; i++ {
-		if vn.finger[i] != vn.successors[0] {
+	for i := 0; i < vn.Last_finger; i++ {
+		if !vn.Finger[i].Equal(vn.Successors[0]) {
 			t.Fatalf("unexpected finger entry!")
 		}
 	}
 
 	// Fix next index
-	if err := vn.fixFingerTable(); err != nil {
+	if err := vn.FixFingerTable(); err != nil {
 		t.Fatalf("unexpected err, %s", err)
 	}
-	if vn.last_finger != 0 {
-		t.Fatalf("unexpected last finger! %d", vn.last_finger)
+	if vn.Last_finger != 0 {
+		t.Fatalf("unexpected last finger! %d", vn.Last_finger)
 	}
 }
 
 func TestVnodeCheckPredNoPred(t *testing.T) {
 	v := makeVnode()
-	v.init(0)
+	v.Init(0)
 	if err := v.checkPredecessor(); err != nil {
 		t.Fatalf("unpexected err! %s", err)
 	}
@@ -471,14 +494,14 @@ func TestVnodeCheckLivePred(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn2.predecessor = &vn1.Vnode
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn2.Predecessor = &vn1.Vnode
 
 	if err := vn2.checkPredecessor(); err != nil {
 		t.Fatalf("unexpected error! %s", err)
 	}
-	if vn2.predecessor != &vn1.Vnode {
+	if vn2.Predecessor != &vn1.Vnode {
 		t.Fatalf("unexpected pred")
 	}
 }
@@ -487,9 +510,9 @@ func TestVnodeCheckDeadPred(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
 
-	vn1 := r.vnodes[0]
-	vn2 := r.vnodes[1]
-	vn2.predecessor = &vn1.Vnode
+	vn1 := r.Vnodes[0]
+	vn2 := r.Vnodes[1]
+	vn2.Predecessor = &vn1.Vnode
 
 	// Deregister vn1
 	(r.transport.(*LocalTransport)).Deregister(&vn1.Vnode)
@@ -497,7 +520,7 @@ func TestVnodeCheckDeadPred(t *testing.T) {
 	if err := vn2.checkPredecessor(); err != nil {
 		t.Fatalf("unexpected error! %s", err)
 	}
-	if vn2.predecessor != nil {
+	if vn2.Predecessor != nil {
 		t.Fatalf("unexpected pred")
 	}
 }
@@ -505,9 +528,9 @@ func TestVnodeCheckDeadPred(t *testing.T) {
 func TestVnodeFindSuccessors(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
-	num := len(r.vnodes)
+	num := len(r.Vnodes)
 	for i := 0; i < num; i++ {
-		r.vnodes[i].successors[0] = &r.vnodes[(i+1)%num].Vnode
+		r.Vnodes[i].Successors[0] = &r.Vnodes[(i+1)%num].Vnode
 	}
 
 	// Get a random key
@@ -517,18 +540,18 @@ func TestVnodeFindSuccessors(t *testing.T) {
 
 	// Local only, should be nearest in the ring
 	nearest := r.nearestVnode(key)
-	exp := nearest.successors[0]
+	exp := nearest.Successors[0]
 
 	// Do a lookup on the key
-	for i := 0; i < len(r.vnodes); i++ {
-		vn := r.vnodes[i]
+	for i := 0; i < len(r.Vnodes); i++ {
+		vn := r.Vnodes[i]
 		succ, err := vn.FindSuccessors(1, key)
 		if err != nil {
 			t.Fatalf("unexpected err! %s", err)
 		}
 
 		// Local only, should be nearest in the ring
-		if exp != succ[0] {
+		if !exp.Equal(succ[0]) {
 			t.Fatalf("unexpected succ! K:%x Exp: %s Got:%s",
 				key, exp, succ[0])
 		}
@@ -539,11 +562,11 @@ func TestVnodeFindSuccessors(t *testing.T) {
 func TestVnodeFindSuccessorsMultSucc(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
-	num := len(r.vnodes)
+	num := len(r.Vnodes)
 	for i := 0; i < num; i++ {
-		r.vnodes[i].successors[0] = &r.vnodes[(i+1)%num].Vnode
-		r.vnodes[i].successors[1] = &r.vnodes[(i+2)%num].Vnode
-		r.vnodes[i].successors[2] = &r.vnodes[(i+3)%num].Vnode
+		r.Vnodes[i].Successors[0] = &r.Vnodes[(i+1)%num].Vnode
+		r.Vnodes[i].Successors[1] = &r.Vnodes[(i+2)%num].Vnode
+		r.Vnodes[i].Successors[2] = &r.Vnodes[(i+3)%num].Vnode
 	}
 
 	// Get a random key
@@ -553,18 +576,18 @@ func TestVnodeFindSuccessorsMultSucc(t *testing.T) {
 
 	// Local only, should be nearest in the ring
 	nearest := r.nearestVnode(key)
-	exp := nearest.successors[0]
+	exp := nearest.Successors[0]
 
 	// Do a lookup on the key
-	for i := 0; i < len(r.vnodes); i++ {
-		vn := r.vnodes[i]
+	for i := 0; i < len(r.Vnodes); i++ {
+		vn := r.Vnodes[i]
 		succ, err := vn.FindSuccessors(1, key)
 		if err != nil {
 			t.Fatalf("unexpected err! %s", err)
 		}
 
 		// Local only, should be nearest in the ring
-		if exp != succ[0] {
+		if !exp.Equal(succ[0]) {
 			t.Fatalf("unexpected succ! K:%x Exp: %s Got:%s",
 				key, exp, succ[0])
 		}
@@ -575,15 +598,15 @@ func TestVnodeFindSuccessorsMultSucc(t *testing.T) {
 func TestVnodeFindSuccessorsSomeDead(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
-	num := len(r.vnodes)
+	num := len(r.Vnodes)
 	for i := 0; i < num; i++ {
-		r.vnodes[i].successors[0] = &r.vnodes[(i+1)%num].Vnode
-		r.vnodes[i].successors[1] = &r.vnodes[(i+2)%num].Vnode
+		r.Vnodes[i].Successors[0] = &r.Vnodes[(i+1)%num].Vnode
+		r.Vnodes[i].Successors[1] = &r.Vnodes[(i+2)%num].Vnode
 	}
 
 	// Kill 2 of the nodes
-	(r.transport.(*LocalTransport)).Deregister(&r.vnodes[0].Vnode)
-	(r.transport.(*LocalTransport)).Deregister(&r.vnodes[3].Vnode)
+	(r.transport.(*LocalTransport)).Deregister(&r.Vnodes[0].Vnode)
+	(r.transport.(*LocalTransport)).Deregister(&r.Vnodes[3].Vnode)
 
 	// Get a random key
 	h := r.config.HashFunc()
@@ -592,18 +615,18 @@ func TestVnodeFindSuccessorsSomeDead(t *testing.T) {
 
 	// Local only, should be nearest in the ring
 	nearest := r.nearestVnode(key)
-	exp := nearest.successors[0]
+	exp := nearest.Successors[0]
 
 	// Do a lookup on the key
-	for i := 0; i < len(r.vnodes); i++ {
-		vn := r.vnodes[i]
+	for i := 0; i < len(r.Vnodes); i++ {
+		vn := r.Vnodes[i]
 		succ, err := vn.FindSuccessors(1, key)
 		if err != nil {
 			t.Fatalf("(%d) unexpected err! %s", i, err)
 		}
 
 		// Local only, should be nearest in the ring
-		if exp != succ[0] {
+		if !exp.Equal(succ[0]) {
 			t.Fatalf("(%d) unexpected succ! K:%x Exp: %s Got:%s",
 				i, key, exp, succ[0])
 		}
@@ -612,39 +635,39 @@ func TestVnodeFindSuccessorsSomeDead(t *testing.T) {
 
 func TestVnodeClearPred(t *testing.T) {
 	v := makeVnode()
-	v.init(0)
+	v.Init(0)
 	p := &Vnode{Id: []byte{12}}
-	v.predecessor = p
+	v.Predecessor = p
 	v.ClearPredecessor(p)
-	if v.predecessor != nil {
+	if v.Predecessor != nil {
 		t.Fatalf("expect no predecessor!")
 	}
 
 	np := &Vnode{Id: []byte{14}}
-	v.predecessor = p
+	v.Predecessor = p
 	v.ClearPredecessor(np)
-	if v.predecessor != p {
+	if v.Predecessor != p {
 		t.Fatalf("expect p predecessor!")
 	}
 }
 
 func TestVnodeSkipSucc(t *testing.T) {
 	v := makeVnode()
-	v.init(0)
+	v.Init(0)
 
 	s1 := &Vnode{Id: []byte{10}}
 	s2 := &Vnode{Id: []byte{11}}
 	s3 := &Vnode{Id: []byte{12}}
 
-	v.successors[0] = s1
-	v.successors[1] = s2
-	v.successors[2] = s3
+	v.Successors[0] = s1
+	v.Successors[1] = s2
+	v.Successors[2] = s3
 
 	// s2 should do nothing
 	if err := v.SkipSuccessor(s2); err != nil {
 		t.Fatalf("unexpected err")
 	}
-	if v.successors[0] != s1 {
+	if v.Successors[0] != s1 {
 		t.Fatalf("unexpected suc")
 	}
 
@@ -652,7 +675,7 @@ func TestVnodeSkipSucc(t *testing.T) {
 	if err := v.SkipSuccessor(s1); err != nil {
 		t.Fatalf("unexpected err")
 	}
-	if v.successors[0] != s2 {
+	if v.Successors[0] != s2 {
 		t.Fatalf("unexpected suc")
 	}
 	if v.knownSuccessors() != 2 {
@@ -663,22 +686,44 @@ func TestVnodeSkipSucc(t *testing.T) {
 func TestVnodeLeave(t *testing.T) {
 	r := makeRing()
 	sort.Sort(r)
-	num := len(r.vnodes)
+	num := len(r.Vnodes)
 	for i := int(0); i < num; i++ {
-		r.vnodes[i].predecessor = &r.vnodes[(i+num-1)%num].Vnode
-		r.vnodes[i].successors[0] = &r.vnodes[(i+1)%num].Vnode
-		r.vnodes[i].successors[1] = &r.vnodes[(i+2)%num].Vnode
+		r.Vnodes[i].Predecessor = &r.Vnodes[(i+num-1)%num].Vnode
+		r.Vnodes[i].Successors[0] = &r.Vnodes[(i+1)%num].Vnode
+		r.Vnodes[i].Successors[1] = &r.Vnodes[(i+2)%num].Vnode
 	}
 
 	// Make node 0 leave
-	if err := r.vnodes[0].leave(); err != nil {
+	if err := r.Vnodes[0].Leave(); err != nil {
 		t.Fatalf("unexpected err")
 	}
 
-	if r.vnodes[4].successors[0] != &r.vnodes[1].Vnode {
+	if r.Vnodes[4].Successors[0] != &r.Vnodes[1].Vnode {
 		t.Fatalf("unexpected suc!")
 	}
-	if r.vnodes[1].predecessor != nil {
+	if r.Vnodes[1].Predecessor != nil {
 		t.Fatalf("unexpected pred!")
 	}
 }
+
+func TestVnodeEqual(t *testing.T) {
+	a := &Vnode{Id: []byte{42, 1}, Host: "test"}
+	b := &Vnode{Id: []byte{42, 2}, Host: "test"}
+	c := &Vnode{Id: []byte{42, 1}, Host: "other"}
+	if a.String() != b.String() {
+		t.Fatalf("expected the same short form")
+	}
+	if a.Equal(b) || a.Equal(c) || a.Equal(nil) {
+		t.Fatalf("unexpected equal")
+	}
+	if !a.Equal(&Vnode{Id: []byte{42, 1}, Host: "test"}) {
+		t.Fatalf("expected equal")
+	}
+	var none *Vnode
+	if !none.Equal(nil) || none.Equal(a) {
+		t.Fatalf("unexpected nil equality")
+	}
+	if a.ident() == b.ident() || a.ident() == c.ident() {
+		t.Fatalf("colliding ident")
+	}
+}
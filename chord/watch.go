@@ -0,0 +1,395 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of events buffered for a watch before older ones are dropped
+	watchBuffer = 64
+
+	// Number of stabilizations after which a watch that cannot be
+	// reached is dropped, by the owner once it is no longer renewed,
+	// or by the watching ring failing to renew it with the owner
+	watchMissedRenewals = 4
+)
+
+// Returns the time after which a watch that cannot be reached is dropped
+func watchExpiry(conf *Config) time.Duration {
+	return watchMissedRenewals * conf.StabilizeMax
+}
+
+// WatchEvent describes the state of a watched key after a change
+type WatchEvent struct {
+	Key     []byte
+	Value   []byte // New value, or one of them if concurrent writes conflict
+	Deleted bool   // Whether the key no longer has a value
+	Version uint64 // Version of the key, as returned by GetWithVersion
+	Seq     uint64 // Orders the events sent by the owner of the key
+}
+
+// Builds the event describing the state of an item, which may be nil
+func watchEventFor(key []byte, item *Item) *WatchEvent {
+	ev := &WatchEvent{Key: key, Deleted: true}
+	if item == nil {
+		return ev
+	}
+	ev.Version = item.Version
+	if live := liveVersions(item.versions(), time.Now()); len(live) > 0 {
+		ev.Value, ev.Deleted = live[0].Value, false
+	}
+	return ev
+}
+
+// Checks if two events describe the same state
+func (ev *WatchEvent) sameState(other *WatchEvent) bool {
+	return other != nil && ev.Deleted == other.Deleted &&
+		ev.Version == other.Version && bytes.Equal(ev.Value, other.Value)
+}
+
+/*
+Watch delivers the changes made to a key. The vnode owning the key
+keeps track of the watch and pushes an event to us after each change.
+The watch is renewed with the owner on every stabilization, sending an
+event if the key changed in the meantime, so changes whose push failed
+are caught up on. When the key moves to another vnode, the watch is
+moved along with it. If events are not read fast enough, the oldest
+are dropped, so the last event always holds the latest state. If the
+owner cannot be reached for several stabilizations, the watch is
+stopped and C is closed.
+*/
+type Watch struct {
+	C <-chan *WatchEvent // Events, closed once the watch is stopped
+
+	ring *Ring
+	id   string
+	key  []byte
+	ch   chan *WatchEvent
+
+	lock    sync.Mutex
+	owner   *Vnode      // Vnode tracking the watch
+	renewed time.Time   // Time the owner last acknowledged the watch
+	last    *WatchEvent // Latest state seen
+	seq     uint64      // Sequence of the latest event from owner
+	stopped bool
+}
+
+// Watch starts watching a key for changes. Only changes made after
+// Watch returns are delivered.
+func (r *Ring) Watch(key []byte) (*Watch, error) {
+	ch := make(chan *WatchEvent, watchBuffer)
+	w := &Watch{C: ch, ring: r, key: key, ch: ch}
+
+	r.watchLock.Lock()
+	r.watchSeq++
+	w.id = fmt.Sprintf("%s/%d", r.config.Hostname, r.watchSeq)
+	if r.watches == nil {
+		r.watches = make(map[string]*Watch)
+	}
+	r.watches[w.id] = w
+	r.watchLock.Unlock()
+
+	if err := w.subscribe(); err != nil {
+		r.watchLock.Lock()
+		delete(r.watches, w.id)
+		r.watchLock.Unlock()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Stop ends the watch and closes its channel
+func (w *Watch) Stop() {
+	w.ring.watchLock.Lock()
+	delete(w.ring.watches, w.id)
+	w.ring.watchLock.Unlock()
+
+	w.lock.Lock()
+	if w.stopped {
+		w.lock.Unlock()
+		return
+	}
+	w.stopped = true
+	close(w.ch)
+	owner := w.owner
+	w.lock.Unlock()
+
+	// Unsubscribe without the lock, so a slow owner does not hold up deliver
+	if owner != nil {
+		w.ring.transport.Unwatch(owner, w.key, w.id)
+	}
+}
+
+// Subscribes with the current owner of the key, or renews the watch
+// if it did not change. Sends an event if the key changed since the
+// latest state seen.
+func (w *Watch) subscribe() error {
+	replicas, err := w.ring.replicas(w.key)
+	if err != nil {
+		return err
+	}
+	owner := replicas[0]
+
+	self := &w.ring.Vnodes[0].Vnode
+	item, err := w.ring.transport.Watch(owner, w.key, w.id, self)
+	if err != nil {
+		return &UnreachableError{Vnode: owner, Err: err}
+	}
+	state := watchEventFor(w.key, item)
+
+	w.lock.Lock()
+	if w.stopped {
+		w.lock.Unlock()
+		w.ring.transport.Unwatch(owner, w.key, w.id)
+		return nil
+	}
+	defer w.lock.Unlock()
+
+	// Sequences restart when another owner tracks the watch, or when
+	// the owner may have dropped it after missed renewals
+	prev := w.owner
+	if !prev.Equal(owner) || time.Since(w.renewed) > watchExpiry(w.ring.config) {
+		w.seq = 0
+	}
+	if prev != nil && !prev.Equal(owner) {
+		go w.ring.transport.Unwatch(prev, w.key, w.id)
+	}
+	w.owner, w.renewed = owner, time.Now()
+	if w.last != nil && !state.sameState(w.last) {
+		w.send(state)
+	}
+	w.last = state
+	return nil
+}
+
+// Checks if the owner has not acknowledged the watch for too long
+func (w *Watch) expired() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return time.Since(w.renewed) > watchExpiry(w.ring.config)
+}
+
+// Delivers an event from the owner, dropping those that arrive out
+// of order. Returns false if the watch is stopped.
+func (w *Watch) deliver(ev *WatchEvent) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return false
+	}
+	if ev.Seq <= w.seq {
+		return true
+	}
+	w.seq = ev.Seq
+	if !ev.sameState(w.last) {
+		w.send(ev)
+	}
+	w.last = ev
+	return true
+}
+
+// Queues an event, dropping the oldest one if the buffer is full
+func (w *Watch) send(ev *WatchEvent) {
+	queueEvent(w.ch, ev)
+}
+
+// Queues an event on a channel, dropping the oldest one if it is full
+func queueEvent(ch chan *WatchEvent, ev *WatchEvent) {
+	for {
+		select {
+		case ch <- ev:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Renews our watches with the current owners of their keys, stopping
+// those whose owner could not be reached for too long. Runs at most
+// once per Config.StabilizeMin, since every vnode triggers it.
+func (r *Ring) refreshWatches() {
+	r.watchLock.Lock()
+	if len(r.watches) == 0 || time.Since(r.lastWatchRefresh) < r.config.StabilizeMin {
+		r.watchLock.Unlock()
+		return
+	}
+	r.lastWatchRefresh = time.Now()
+	watches := make([]*Watch, 0, len(r.watches))
+	for _, w := range r.watches {
+		watches = append(watches, w)
+	}
+	r.watchLock.Unlock()
+
+	for _, w := range watches {
+		err := w.subscribe()
+		if err == nil {
+			continue
+		}
+		if w.expired() {
+			r.logger().Warn("Dropping watch", "key", w.key, "error", err)
+			w.Stop()
+		} else {
+			r.logger().Warn("Failed to renew watch", "key", w.key, "error", err)
+		}
+	}
+}
+
+// Stops all of our watches
+func (r *Ring) stopWatches() {
+	r.watchLock.Lock()
+	watches := make([]*Watch, 0, len(r.watches))
+	for _, w := range r.watches {
+		watches = append(watches, w)
+	}
+	r.watchLock.Unlock()
+	for _, w := range watches {
+		w.Stop()
+	}
+}
+
+// A watch tracked by the vnode owning the key
+type watcher struct {
+	subscriber *Vnode           // Vnode of the watching ring
+	seq        uint64           // Sequence of the last event queued
+	renewed    time.Time        // Time the watching ring last renewed the watch
+	events     chan *WatchEvent // Events waiting to be pushed, in order
+	done       chan struct{}    // Closed once the watch is dropped
+}
+
+// RPC: Tracks a watch on a key, or renews it, returning the item
+// stored under it
+func (vn *LocalVnode) Watch(key []byte, id string, subscriber *Vnode) (*Item, error) {
+	vn.watchLock.Lock()
+	if vn.watchers == nil {
+		vn.watchers = make(map[string]map[string]*watcher)
+	}
+	if vn.watchers[string(key)] == nil {
+		vn.watchers[string(key)] = make(map[string]*watcher)
+	}
+	sub, ok := vn.watchers[string(key)][id]
+	if !ok {
+		sub = &watcher{events: make(chan *WatchEvent, watchBuffer), done: make(chan struct{})}
+		vn.watchers[string(key)][id] = sub
+		go vn.pushEvents(key, id, sub)
+	}
+	sub.subscriber, sub.renewed = subscriber, time.Now()
+	vn.watchLock.Unlock()
+
+	item, err := vn.Store.Get(key)
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	return item, err
+}
+
+// RPC: Stops tracking a watch on a key
+func (vn *LocalVnode) Unwatch(key []byte, id string) error {
+	vn.watchLock.Lock()
+	defer vn.watchLock.Unlock()
+	vn.removeWatcher(key, id)
+	return nil
+}
+
+// Removes a watch. The watch lock must be held.
+func (vn *LocalVnode) removeWatcher(key []byte, id string) {
+	subs := vn.watchers[string(key)]
+	if sub, ok := subs[id]; ok {
+		close(sub.done)
+	}
+	delete(subs, id)
+	if len(subs) == 0 {
+		delete(vn.watchers, string(key))
+	}
+}
+
+// RPC: Delivers an event to one of our ring's watches. Returns
+// false if the watch is no longer open.
+func (vn *LocalVnode) WatchEvent(id string, ev *WatchEvent) (bool, error) {
+	vn.Ring.watchLock.Lock()
+	w, ok := vn.Ring.watches[id]
+	vn.Ring.watchLock.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return w.deliver(ev), nil
+}
+
+// Queues the new state of a key to be pushed to its watches, if any.
+// Once a watch falls behind, its oldest events are dropped, as the
+// latest one holds the current state.
+func (vn *LocalVnode) notifyWatchers(key []byte, item *Item) {
+	vn.watchLock.Lock()
+	defer vn.watchLock.Unlock()
+	subs := vn.watchers[string(key)]
+	if len(subs) == 0 {
+		return
+	}
+	state := watchEventFor(key, item)
+	for _, sub := range subs {
+		sub.seq++
+		ev := *state
+		ev.Seq = sub.seq
+		queueEvent(sub.events, &ev)
+	}
+}
+
+// Pushes the queued events of a watch in order until it is dropped.
+// Watches that are closed are dropped, as are those that cannot be
+// reached and were not renewed for too long. Others catch up once renewed.
+func (vn *LocalVnode) pushEvents(key []byte, id string, sub *watcher) {
+	for {
+		var ev *WatchEvent
+		select {
+		case ev = <-sub.events:
+		case <-sub.done:
+			return
+		}
+
+		vn.watchLock.Lock()
+		target := sub.subscriber
+		vn.watchLock.Unlock()
+		open, err := vn.Ring.transport.WatchEvent(target, id, ev)
+		if err == nil && open {
+			continue
+		}
+
+		vn.watchLock.Lock()
+		if vn.watchers[string(key)][id] == sub &&
+			(err == nil || time.Since(sub.renewed) > watchExpiry(vn.Ring.config)) {
+			vn.removeWatcher(key, id)
+		}
+		vn.watchLock.Unlock()
+	}
+}
+
+// Drops the watches that were not renewed for too long,
+// as their watching ring is gone or has moved them elsewhere
+func (vn *LocalVnode) expireWatchers() {
+	vn.watchLock.Lock()
+	defer vn.watchLock.Unlock()
+	for key, subs := range vn.watchers {
+		for id, sub := range subs {
+			if time.Since(sub.renewed) > watchExpiry(vn.Ring.config) {
+				vn.removeWatcher([]byte(key), id)
+			}
+		}
+	}
+}
+
+// Drops all the watches tracked by the vnode
+func (vn *LocalVnode) stopWatchers() {
+	vn.watchLock.Lock()
+	defer vn.watchLock.Unlock()
+	for key, subs := range vn.watchers {
+		for id := range subs {
+			vn.removeWatcher([]byte(key), id)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Transport failing the watch traffic to and from other hosts
+type watchFailTrans struct {
+	*MultiLocalTrans
+	failing atomic.Bool
+}
+
+func (w *watchFailTrans) Watch(target *Vnode, key []byte, id string, subscriber *Vnode) (*Item, error) {
+	if w.failing.Load() {
+		return nil, fmt.Errorf("watch failed")
+	}
+	return w.MultiLocalTrans.Watch(target, key, id, subscriber)
+}
+
+func (w *watchFailTrans) WatchEvent(target *Vnode, id string, ev *WatchEvent) (bool, error) {
+	if w.failing.Load() {
+		return false, fmt.Errorf("push failed")
+	}
+	return w.MultiLocalTrans.WatchEvent(target, id, ev)
+}
+
+func (w *watchFailTrans) Unwatch(target *Vnode, key []byte, id string) error {
+	if w.failing.Load() {
+		return fmt.Errorf("unwatch failed")
+	}
+	return w.MultiLocalTrans.Unwatch(target, key, id)
+}
+
+// Creates two rings, with a watch on the second ring of a key owned by the first
+func watchedRemotely(t *testing.T, trans Transport) (*Ring, *Ring, *Watch) {
+	r, err := Create(kvConf("test"), trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	r2, err := Join(kvConf("test2"), trans, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	key, _ := keyOwnedBy(t, r, "test")
+	w, err := r2.Watch(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	return r, r2, w
+}
+
+// Waits for the next event of a watch
+func nextEvent(t *testing.T, w *Watch) *WatchEvent {
+	select {
+	case ev, ok := <-w.C:
+		if !ok {
+			t.Fatalf("watch closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return nil
+}
+
+func TestRingWatch(t *testing.T) {
+	r, err := Create(fastConf(), nil)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	key := []byte("foo")
+	w, err := r.Watch(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	if err := r.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, w); ev.Deleted || !bytes.Equal(ev.Value, []byte("bar")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+
+	if _, err := r.CompareAndSwap(key, 1, []byte("baz")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, w); ev.Version != 2 || !bytes.Equal(ev.Value, []byte("baz")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+
+	if err := r.Delete(key); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, w); !ev.Deleted || ev.Value != nil {
+		t.Fatalf("bad event: %+v", ev)
+	}
+
+	// Other keys are not delivered
+	r.Put([]byte("other"), []byte("bar"))
+	w.Stop()
+	if ev, ok := <-w.C; ok {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if len(r.watches) != 0 {
+		t.Fatalf("watch not removed")
+	}
+}
+
+func TestRingWatchRemote(t *testing.T) {
+	// Create two rings
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	// Watch a key owned by the other host
+	var key []byte
+	for i := 0; key == nil; i++ {
+		candidate := []byte(fmt.Sprintf("key%d", i))
+		replicas, err := r.replicas(candidate)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if replicas[0].Host == "test" {
+			key = candidate
+		}
+	}
+	w, err := r2.Watch(key)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer w.Stop()
+
+	if err := r.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, w); !bytes.Equal(ev.Value, []byte("bar")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+}
+
+func TestRingWatchMoves(t *testing.T) {
+	ml := InitMLTransport()
+	r, err := Create(kvConf("test"), ml)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Watch keys while we own all of them
+	var watches []*Watch
+	for i := 0; i < 20; i++ {
+		w, err := r.Watch([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		defer w.Stop()
+		watches = append(watches, w)
+	}
+
+	// Some keys move to a new host
+	r2, err := Join(kvConf("test2"), ml, "test")
+	if err != nil {
+		t.Fatalf("failed to join local node! Got %s", err)
+	}
+	defer r2.Shutdown()
+
+	// Wait for the successor lists to settle
+	<-time.After(200 * time.Millisecond)
+
+	var moved *Watch
+	for _, w := range watches {
+		replicas, err := r.replicas(w.key)
+		if err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+		if replicas[0].Host == "test2" {
+			moved = w
+			break
+		}
+	}
+	if moved == nil {
+		t.Fatalf("no key moved")
+	}
+	moved.lock.Lock()
+	owner := moved.owner
+	moved.lock.Unlock()
+	if owner.Host != "test2" {
+		t.Fatalf("watch not moved: %s", owner.String())
+	}
+
+	// Changes made on the new owner are delivered
+	if err := r2.Put(moved.key, []byte("bar"), WithWriteQuorum(2)); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, moved); !bytes.Equal(ev.Value, []byte("bar")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+}
+
+func TestTCPWatch(t *testing.T) {
+	listen := "localhost:10045"
+	conf := fastConf()
+	conf.Hostname = listen
+	trans, err := InitTCPTransport(listen, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer trans.Shutdown()
+
+	r, err := Create(conf, trans)
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer r.Shutdown()
+
+	// Talk to the vnodes directly over TCP
+	vn := &r.Vnodes[0].Vnode
+	if item, err := trans.Watch(vn, []byte("foo"), "remote/1", vn); err != nil || item != nil {
+		t.Fatalf("unexpected result. %v %v", item, err)
+	}
+	if err := trans.Unwatch(vn, []byte("foo"), "remote/1"); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+
+	// Push an event to a watch of the ring
+	w, err := r.Watch([]byte("foo"))
+	if err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	defer w.Stop()
+	ev := &WatchEvent{Key: []byte("foo"), Value: []byte("bar"), Seq: 1}
+	if open, err := trans.WatchEvent(vn, w.id, ev); err != nil || !open {
+		t.Fatalf("unexpected result. %v %v", open, err)
+	}
+	if got := nextEvent(t, w); !bytes.Equal(got.Value, []byte("bar")) {
+		t.Fatalf("bad event: %+v", got)
+	}
+	if open, err := trans.WatchEvent(vn, "unknown", ev); err != nil || open {
+		t.Fatalf("unexpected result. %v %v", open, err)
+	}
+}
+
+func TestRingWatchCatchesUp(t *testing.T) {
+	wt := &watchFailTrans{MultiLocalTrans: InitMLTransport()}
+	r, r2, w := watchedRemotely(t, wt)
+	defer r.Shutdown()
+	defer r2.Shutdown()
+	defer w.Stop()
+
+	// A change whose push fails is delivered once the watch is renewed
+	wt.failing.Store(true)
+	if err := r.Put(w.key, []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	<-time.After(20 * time.Millisecond)
+	wt.failing.Store(false)
+	if ev := nextEvent(t, w); !bytes.Equal(ev.Value, []byte("bar")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+
+	// The owner still pushes the later changes
+	if err := r.Put(w.key, []byte("baz")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if ev := nextEvent(t, w); !bytes.Equal(ev.Value, []byte("baz")) {
+		t.Fatalf("bad event: %+v", ev)
+	}
+}
+
+func TestRingWatchDropped(t *testing.T) {
+	wt := &watchFailTrans{MultiLocalTrans: InitMLTransport()}
+	r, r2, w := watchedRemotely(t, wt)
+	defer r.Shutdown()
+	defer r2.Shutdown()
+
+	// The watch is stopped once the owner cannot be reached
+	wt.failing.Store(true)
+	select {
+	case ev, ok := <-w.C:
+		if ok {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the watch to be closed")
+	}
+	r2.watchLock.Lock()
+	defer r2.watchLock.Unlock()
+	if len(r2.watches) != 0 {
+		t.Fatalf("watch not removed")
+	}
+}
+
+// Transport whose Unwatch RPCs stall until released
+type unwatchStallTrans struct {
+	*MultiLocalTrans
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (u *unwatchStallTrans) Unwatch(target *Vnode, key []byte, id string) error {
+	select {
+	case u.entered <- struct{}{}:
+	default:
+	}
+	<-u.release
+	return u.MultiLocalTrans.Unwatch(target, key, id)
+}
+
+func TestWatchStopUnlocked(t *testing.T) {
+	ut := &unwatchStallTrans{MultiLocalTrans: InitMLTransport(),
+		entered: make(chan struct{}, 1), release: make(chan struct{})}
+	r, r2, w := watchedRemotely(t, ut)
+	defer r.Shutdown()
+	defer r2.Shutdown()
+
+	// The watch can still be used while the owner is slow to unsubscribe
+	go w.Stop()
+	<-ut.entered
+	done := make(chan bool)
+	go func() {
+		done <- w.deliver(&WatchEvent{Key: w.key, Seq: 1})
+	}()
+	select {
+	case open := <-done:
+		if open {
+			t.Fatalf("expected the watch to be stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("deliver blocked on Unwatch")
+	}
+	close(ut.release)
+}
+
+// Returns the sequence of the latest event delivered to a watch,
+// waiting up to a second for it to reach want
+func watchSeq(w *Watch, want uint64) uint64 {
+	for i := 0; ; i++ {
+		w.lock.Lock()
+		seq := w.seq
+		w.lock.Unlock()
+		if seq == want || i == 100 {
+			return seq
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+}
+
+func TestRingWatchRetracked(t *testing.T) {
+	r, r2, w := watchedRemotely(t, InitMLTransport())
+	defer r.Shutdown()
+	defer r2.Shutdown()
+	defer w.Stop()
+
+	// The owner numbers the events of a watch from the start
+	if err := r.Put(w.key, []byte("bar")); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if seq := watchSeq(w, 1); seq != 1 {
+		t.Fatalf("bad sequence: %d", seq)
+	}
+
+	// The owner drops the watch after missed renewals, and
+	// numbers its events from the start once renewed again
+	w.lock.Lock()
+	owner := w.owner
+	w.renewed = time.Now().Add(-2 * watchExpiry(r.config))
+	w.lock.Unlock()
+	if err := r.transport.Unwatch(owner, w.key, w.id); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	if err := w.subscribe(); err != nil {
+		t.Fatalf("unexpected err. %s", err)
+	}
+	vn := ringVnode(owner, r)
+	vn.watchLock.Lock()
+	sub := vn.watchers[string(w.key)][w.id]
+	vn.watchLock.Unlock()
+	if sub == nil || sub.seq != 0 {
+		t.Fatalf("expected the watch to be tracked anew")
+	}
+	if seq := watchSeq(w, 0); seq != 0 {
+		t.Fatalf("bad sequence: %d", seq)
+	}
+}
+
+func TestRingWatchExpires(t *testing.T) {
+	wt := &watchFailTrans{MultiLocalTrans: InitMLTransport()}
+	r, r2, w := watchedRemotely(t, wt)
+	defer r.Shutdown()
+	defer r2.Shutdown()
+
+	// The owner drops the watch once it is no longer renewed,
+	// even if the key does not change
+	wt.failing.Store(true)
+	<-time.After(2 * watchExpiry(r.config))
+	for _, vn := range r.Vnodes {
+		vn.watchLock.Lock()
+		n := len(vn.watchers)
+		vn.watchLock.Unlock()
+		if n != 0 {
+			t.Fatalf("watch not dropped by %s", vn.String())
+		}
+	}
+	if _, ok := <-w.C; ok {
+		t.Fatalf("expected the watch to be closed")
+	}
+}
+
+// Transport with slow event pushes, tracking how many run at once
+type slowPushTrans struct {
+	*MultiLocalTrans
+	running atomic.Int32
+	most    atomic.Int32
+}
+
+func (s *slowPushTrans) WatchEvent(target *Vnode, id string, ev *WatchEvent) (bool, error) {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	if n > s.most.Load() {
+		s.most.Store(n)
+	}
+	<-time.After(5 * time.Millisecond)
+	return s.MultiLocalTrans.WatchEvent(target, id, ev)
+}
+
+func TestRingWatchPushesInOrder(t *testing.T) {
+	st := &slowPushTrans{MultiLocalTrans: InitMLTransport()}
+	r, r2, w := watchedRemotely(t, st)
+	defer r.Shutdown()
+	defer r2.Shutdown()
+	defer w.Stop()
+
+	// Wait for the watch to be tracked by the vnode owning the key
+	_, owner := keyOwnedBy(t, r, "test")
+	for i := 0; i < 100; i++ {
+		w.lock.Lock()
+		moved := w.owner.Equal(owner)
+		w.lock.Unlock()
+		if moved {
+			break
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+
+	// Changes are pushed one at a time, in order
+	for i := 0; i < 10; i++ {
+		if err := r.Put(w.key, []byte(fmt.Sprintf("val%d", i))); err != nil {
+			t.Fatalf("unexpected err. %s", err)
+		}
+	}
+	if seq := watchSeq(w, 10); seq != 10 {
+		t.Fatalf("bad sequence: %d", seq)
+	}
+	if most := st.most.Load(); most != 1 {
+		t.Fatalf("expected a single push at a time, got %d", most)
+	}
+
+	// The latest state is delivered last
+	var last *WatchEvent
+	for len(w.C) > 0 {
+		last = <-w.C
+	}
+	if last == nil || !bytes.Equal(last.Value, []byte("val9")) {
+		t.Fatalf("bad event: %+v", last)
+	}
+}
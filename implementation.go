@@ -87,6 +87,7 @@ func main(){
 		fmt.Println("\t3: PUT key-value into store")
 		fmt.Println("\t4: GET key-value from store")
 		fmt.Println("\t5: Display key-value pairs in current node")
+		fmt.Println("\t6: Display key-value pairs across the ring")
 		fmt.Println("\t0: Exit")
 
 		fmt.Println("Enter your choice")
@@ -110,36 +111,61 @@ func main(){
 			fmt.Println("Enter Value: ")
 			fmt.Scanf("%s", &value)
 
-			r1.Vnodes[1].Successors[0].Map[key] = value
+			if err := r1.Put([]byte(key), []byte(value)); err != nil {
+				fmt.Println("Failed to store key:", err)
+			}
+
+		} else if i == 4 {
+
+			key := ""
+
+			fmt.Println("Enter Key: ")
+			fmt.Scanf("%s", &key)
+
+			value, err := r1.Get([]byte(key))
+			if err != nil {
+				fmt.Println("Failed to read key:", err)
+			} else {
+				fmt.Println("\t", key, "-", string(value))
+			}
 
 		} else if i == 5 {
 			
 			//vn := r1.Vnodes[0].Successors[0]
 			//fmt.Println(vn.Last_finger)
 			
-			fmt.Println("Key-Values at VNode-1")
-			//fmt.Println(r1.Vnodes[0].GetKey())
-			for key, value := range r1.Vnodes[0].Map {
- 	  			fmt.Println("\t", key, "-", value)
- 	  		}
-
- 	  		fmt.Println("Key-Values at VNode-2")
- 	  		//fmt.Println(r1.Vnodes[1].GetKey())
-			for key, value := range r1.Vnodes[1].Map {
- 	  			fmt.Println("\t", key, "-", value)
- 	  		}
- 	  			
+			// Skip the tombstones and expired values
+			now := time.Now()
+			for idx, vn := range r1.Vnodes {
+				fmt.Printf("Key-Values at VNode-%d\n", idx+1)
+				vn.Store.Range(vn.Id, vn.Id, func(item *chord.Item) bool {
+					if live := item.Live(now); live != nil {
+						fmt.Println("\t", string(live.Key), "-", string(live.Value))
+					}
+					return true
+				})
+			}
+
+		} else if i == 6 {
+
+			it := r1.Iterate(r1.Vnodes[0].Id, 100)
+			for it.Next() {
+				fmt.Println("\t", string(it.Item().Key), "-", string(it.Item().Value))
+			}
+			if err := it.Err(); err != nil {
+				fmt.Println("Failed to list keys:", err)
+			}
+
 		} else if i == 0 {
 			
 			break
 		}
 	}
 
-	for i := 0; i < r1.Len(); i++ {
-		r1.Vnodes[i].Leave()
+	// Hand off our keys before leaving
+	if err := r1.Leave(); err != nil {
+		fmt.Println("Failed to leave cleanly:", err)
 	}
-
-	r1.Shutdown()
 	t1.Shutdown()
 
 }